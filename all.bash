@@ -80,7 +80,7 @@ ensure_go_binary() {
 
 # bad_migrations outputs migrations with bad sequence numbers.
 bad_migrations() {
-  ls etc/migrations | cut -d _ -f 1 | sort | uniq -c | grep -vE '^\s+2 '
+  ls etc/migrations | grep '\.sql$' | cut -d _ -f 1 | sort | uniq -c | grep -vE '^\s+2 '
 }
 
 # check_bad_migrations looks for sql migration files with bad sequence numbers,
@@ -19,6 +19,11 @@ func frontendConfig(name, version, commit, date string) (cfg frontend.Config) {
 	if err := env.Parse(&cfg.DB); err != nil {
 		panic(err)
 	}
+	switch cfg.SchemaMismatch {
+	case frontend.SchemaMismatchFail, frontend.SchemaMismatchReadOnly, frontend.SchemaMismatchWarn:
+	default:
+		panic(fmt.Errorf("invalid SCHEMA_MISMATCH %q", cfg.SchemaMismatch))
+	}
 	cfg.Name = name
 	cfg.VersionID = version
 	cfg.VersionCommitHash = commit
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -17,7 +18,9 @@ import (
 	"contrib.go.opencensus.io/exporter/prometheus"
 	"github.com/tetsuo/fortune/cmd/internal/cmdconfig"
 	"github.com/tetsuo/fortune/cmd/internal/dcensus"
+	"github.com/tetsuo/fortune/etc/migrations"
 	"github.com/tetsuo/fortune/frontend"
+	"github.com/tetsuo/fortune/internal/database"
 	"github.com/tetsuo/fortune/internal/middleware"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/trace"
@@ -91,6 +94,10 @@ func main() {
 		sigs <- syscall.SIGINT
 	}
 
+	// schemaStatus describes the database schema for the debug server,
+	// once checked.
+	var schemaStatus atomic.Pointer[string]
+
 	// debug server enabled?
 	var debugServer *http.Server
 	if !cfg.DisableDebugServer {
@@ -105,8 +112,16 @@ func main() {
 			if err := view.Register(frontend.ServerViews...); err != nil {
 				log.Fatalf("error registering frontend server views: %v", err)
 			}
+			if err := view.Register(cmdconfig.DBViews...); err != nil {
+				log.Fatalf("error registering database views: %v", err)
+			}
 		}
-		debugHandler, err := dcensus.ServeDebug(pe, !cfg.DisablePProfEndpoint, version, cfg.PodName(), cfg.Name, cfg.VersionCommitHash, cfg.VersionCommitDate)
+		debugHandler, err := dcensus.ServeDebug(pe, !cfg.DisablePProfEndpoint, version, cfg.PodName(), cfg.Name, cfg.VersionCommitHash, cfg.VersionCommitDate, func() string {
+			if status := schemaStatus.Load(); status != nil {
+				return *status
+			}
+			return "not checked yet"
+		})
 		if err != nil {
 			log.Fatalf("error initializing debug server: %v", err)
 		}
@@ -160,16 +175,78 @@ func main() {
 		)
 	}
 
-	db, err := cmdconfig.OpenDB(context.Background(), cfg.InstanceID, cfg.DB)
+	// The server starts without a database and responds with 503 Service
+	// Unavailable until connected.
+	s, err := frontend.NewServer(cfg, nil, er)
 	if err != nil {
-		log.Fatalf("error opening DB: %v", err)
+		log.Fatalf("error initializing server: %v", err)
 	}
 
-	s, err := frontend.NewServer(cfg, db, er)
-	if err != nil {
-		log.Fatalf("error initializing server: %v", err)
+	dbCtx, cancelDB := context.WithCancel(context.Background())
+	dbc := make(chan *database.DB, 1)
+
+	// startupFailed is set when the server shuts down because it could
+	// not start serving, so that it exits with a non-zero status.
+	var startupFailed atomic.Bool
+
+	// failStartup logs why the server cannot start serving and shuts it
+	// down. Errors caused by a shutdown that is already under way, which
+	// cancels dbCtx, are not failures.
+	failStartup := func(format string, args ...any) {
+		log.Errorf(format, args...)
+		if dbCtx.Err() == nil {
+			startupFailed.Store(true)
+		}
+		shutdown()
 	}
 
+	go func() {
+		db, err := cmdconfig.OpenDB(dbCtx, cfg.InstanceID, cfg.DB)
+		dbc <- db
+		if err != nil {
+			failStartup("error opening DB: %v", err)
+			return
+		}
+		if cfg.AutoMigrate {
+			log.Info("migrating database")
+			version, err := db.Migrate(dbCtx, migrations.FS, cfg.MigrateLockTimeout)
+			if err != nil {
+				failStartup("error migrating database: %v", err)
+				return
+			}
+			log.Infof("database is at version %d", version)
+		}
+		state, err := db.CheckSchema(dbCtx, migrations.FS)
+		if err != nil {
+			failStartup("error checking database schema: %v", err)
+			return
+		}
+		status := state.String()
+		if !state.Current() {
+			switch cfg.SchemaMismatch {
+			case frontend.SchemaMismatchFail:
+				status += ", refused to serve"
+				schemaStatus.Store(&status)
+				failStartup("database schema is at %s, refusing to serve", state)
+				return
+			case frontend.SchemaMismatchReadOnly:
+				log.Warnf("database schema is at %s, serving read-only", state)
+				status += ", read-only"
+				s.SetReadOnly()
+			default:
+				log.Warnf("database schema is at %s", state)
+			}
+		}
+		schemaStatus.Store(&status)
+		if !cfg.DisableDebugServer && !cfg.DisablePrometheusEndpoint {
+			if _, err := db.RegisterPoolMetrics(); err != nil {
+				log.Errorf("error registering database pool metrics: %v", err)
+			}
+		}
+		s.SetDB(db)
+		log.Info("frontend server is ready")
+	}()
+
 	errorReportingMiddleware := middleware.Empty()
 	if er != nil {
 		errorReportingMiddleware = middleware.ErrorReporting(er.Report)
@@ -196,6 +273,12 @@ func main() {
 
 	wg.Wait()
 
+	s.StartShutdown()
+	if cfg.ShutdownDelay > 0 {
+		log.Infof("waiting %s for traffic to drain", cfg.ShutdownDelay)
+		time.Sleep(cfg.ShutdownDelay)
+	}
+
 	log.Infof("shutting down frontend server on %s", cfg.ServerAddress())
 	if err := server.Shutdown(context.Background()); err != nil {
 		log.Errorf("error shutting down frontend server: %v", err)
@@ -208,9 +291,12 @@ func main() {
 		}
 	}
 
-	log.Infof("disconnecting from db")
-	if err := db.Close(); err != nil {
-		log.Errorf("error disconnecting from db: %v", err)
+	cancelDB()
+	if db := <-dbc; db != nil {
+		log.Infof("disconnecting from db")
+		if err := db.Close(); err != nil {
+			log.Errorf("error disconnecting from db: %v", err)
+		}
 	}
 
 	if er != nil {
@@ -219,5 +305,10 @@ func main() {
 		}
 	}
 
+	if startupFailed.Load() {
+		log.Error("exiting after failing to start")
+		os.Exit(1)
+	}
+
 	log.Info("exiting gracefully")
 }
@@ -3,15 +3,44 @@ package cmdconfig
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"contrib.go.opencensus.io/integrations/ocsql"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/tetsuo/fortune/internal/database"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
 	"go.uber.org/zap"
 )
 
-// OpenDB opens the MySQL database specified by the config.
+var (
+	keyConnectStatus = tag.MustNewKey("fortune.db.connect_status")
+
+	dbConnectAttempts = stats.Int64(
+		"fortune/db/connect_attempts",
+		"Attempts to connect to the database",
+		stats.UnitDimensionless,
+	)
+
+	// DBConnectAttemptCount counts the attempts to connect to the database
+	// by status, ok or error.
+	DBConnectAttemptCount = &view.View{
+		Name:        "fortune/db/connect_attempt_count",
+		Description: "Count of attempts to connect to the database by status",
+		TagKeys:     []tag.Key{keyConnectStatus},
+		Measure:     dbConnectAttempts,
+		Aggregation: view.Count(),
+	}
+
+	// DBViews are the OpenCensus views of OpenDB.
+	DBViews = []*view.View{DBConnectAttemptCount}
+)
+
+// OpenDB opens the MySQL database specified by the config. Connecting is
+// retried until it succeeds, ctx is done, or the DBConnectTimeout of cfg
+// expires.
 func OpenDB(ctx context.Context, instanceID string, cfg database.DBConfig) (_ *database.DB, err error) {
 	log := zap.S()
 
@@ -28,12 +57,67 @@ func OpenDB(ctx context.Context, instanceID string, cfg database.DBConfig) (_ *d
 		"user", cfg.DBUser,
 	).Infof("opening database on host %s", cfg.DBHost)
 
-	db, err := database.Open(ocDriver, cfg.DSN(), instanceID)
+	db, err := openWithRetry(ctx, ocDriver, instanceID, cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	if dsns := cfg.ReadDSNs(); len(dsns) > 0 {
+		log.With("hosts", cfg.DBReadHosts).Infof("opening %d read replicas", len(dsns))
+		if err := db.OpenReplicas(ocDriver, dsns); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	db.ConfigurePool(cfg)
+	log.With(
+		"maxOpenConns", cfg.DBMaxOpenConns,
+		"maxIdleConns", cfg.DBMaxIdleConns,
+		"connMaxLifetime", cfg.DBConnMaxLifetime,
+		"connMaxIdleTime", cfg.DBConnMaxIdleTime,
+	).Debug("configured database connection pool")
+
 	log.Debug("database open finished")
 
 	return db, nil
 }
+
+// openWithRetry opens the primary database, retrying with exponential
+// backoff as configured by cfg.
+func openWithRetry(ctx context.Context, driverName, instanceID string, cfg database.DBConfig) (*database.DB, error) {
+	log := zap.S()
+
+	if cfg.DBConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.DBConnectTimeout)
+		defer cancel()
+	}
+	backoff := max(cfg.DBConnectMinBackoff, time.Millisecond)
+	maxBackoff := max(cfg.DBConnectMaxBackoff, backoff)
+
+	for attempt := 1; ; attempt++ {
+		db, err := database.Open(ctx, driverName, cfg.DSN(), instanceID)
+
+		status := "ok"
+		if err != nil {
+			status = "error"
+		}
+		_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(keyConnectStatus, status)}, dbConnectAttempts.M(1))
+
+		if err == nil {
+			if attempt > 1 {
+				log.Infof("connected to database after %d attempts", attempt)
+			}
+			return db, nil
+		}
+
+		log.With("attempt", attempt).Warnf("error connecting to database, retrying in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("giving up connecting to database after %d attempts: %w", attempt, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
@@ -2,6 +2,7 @@ package dcensus
 
 import (
 	"fmt"
+	"html"
 	"net/http"
 	"os"
 
@@ -13,8 +14,12 @@ import (
 	"go.uber.org/zap"
 )
 
-func debugHomeHandler(versionID, instanceID, name, commitHash, commitDate string) http.HandlerFunc {
+func debugHomeHandler(versionID, instanceID, name, commitHash, commitDate string, schemaStatus func() string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		schema := "unknown"
+		if schemaStatus != nil {
+			schema = schemaStatus()
+		}
 		fmt.Fprintf(w, `
 <html>
 <style>
@@ -30,6 +35,7 @@ func debugHomeHandler(versionID, instanceID, name, commitHash, commitDate string
 		version=%s
 		commit=%s
 		date=%s
+		schema=%s
 	</code>
 	<ul>
 	<li><a href="/tracez">Traces</a></li>
@@ -39,7 +45,7 @@ func debugHomeHandler(versionID, instanceID, name, commitHash, commitDate string
 	</ul>
 </body>
 </html>
-`, name, instanceID, versionID, commitHash, commitDate)
+`, name, instanceID, versionID, commitHash, commitDate, html.EscapeString(schema))
 	}
 }
 
@@ -106,8 +112,9 @@ func (s *debugServer) debugInfoHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "</body></html>\n")
 }
 
-// ServeDebug serves the internal debug server.
-func ServeDebug(pe *prometheus.Exporter, pprofEnabled bool, versionID, instanceID, name, commit, date string) (http.Handler, error) {
+// ServeDebug serves the internal debug server. The home page shows the
+// database schema status returned by schemaStatus, if not nil.
+func ServeDebug(pe *prometheus.Exporter, pprofEnabled bool, versionID, instanceID, name, commit, date string, schemaStatus func() string) (http.Handler, error) {
 	mux := http.NewServeMux()
 	zpages.Handle(mux, "/")
 	if pe != nil {
@@ -115,7 +122,7 @@ func ServeDebug(pe *prometheus.Exporter, pprofEnabled bool, versionID, instanceI
 		// https://github.com/GoogleCloudPlatform/prometheus-engine/blob/v0.4.1/doc/api.md#scrapeendpoint
 		mux.Handle("/metrics", pe)
 	}
-	mux.HandleFunc("/", debugHomeHandler(versionID, instanceID, name, commit, date))
+	mux.HandleFunc("/", debugHomeHandler(versionID, instanceID, name, commit, date, schemaStatus))
 	if pprofEnabled {
 		zap.S().Info("enabling pprof handler")
 		mux.Handle("/debug/pprof/", http.HandlerFunc(hpprof.Index))
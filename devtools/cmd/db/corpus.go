@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tetsuo/fortune/frontend"
+	"github.com/tetsuo/fortune/internal/cookiefile"
+	"github.com/tetsuo/fortune/internal/cookieindex"
+	"github.com/tetsuo/fortune/internal/database"
+)
+
+// indexExt is the extension of the strfile index of a cookie file, which
+// is named after the file.
+const indexExt = ".dat"
+
+// offensiveDir is the name of the directories of offensive cookie files,
+// like in the distributions of the Unix fortune command.
+const offensiveDir = "off"
+
+// seed loads the cookie files named in args into the database. The
+// collection of a file is its name without extension, and its fortunes are
+// offensive if it is in an "off" directory, unless set by flags. A
+// directory stands for its files and those of its "off" subdirectory, as
+// written by dump -out-dir. The strfile index of a file, FILE.dat, is checked
+// against it if it exists, and the fortunes of a rotated file are
+// offensive.
+func seed(ctx context.Context, cfg database.DBConfig, args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	collection := fs.String("collection", "", "collection of all the fortunes, instead of the file names")
+	offensive := fs.Bool("offensive", false, "mark all the fortunes as offensive")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: db seed [flags] file|dir|- ...\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("seed: no files")
+	}
+
+	var files []string
+	for _, arg := range fs.Args() {
+		paths, err := cookieFiles(arg)
+		if err != nil {
+			return err
+		}
+		files = append(files, paths...)
+	}
+
+	db, err := openDB(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, path := range files {
+		name := *collection
+		if name == "" {
+			if path == "-" {
+				return errors.New("seed: -collection is required to read from standard input")
+			}
+			name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		off := *offensive || filepath.Base(filepath.Dir(path)) == offensiveDir
+		if err := seedFile(ctx, db, path, name, off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cookieFiles returns the cookie files named by arg: arg itself, unless it
+// is a directory, in which case its files and those of its "off"
+// subdirectory.
+func cookieFiles(arg string) ([]string, error) {
+	if arg == "-" {
+		return []string{arg}, nil
+	}
+	fi, err := os.Stat(arg)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{arg}, nil
+	}
+	var files []string
+	for _, dir := range []string{arg, filepath.Join(arg, offensiveDir)} {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) && dir != arg {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() && filepath.Ext(e.Name()) != indexExt {
+				files = append(files, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// seedFile loads the cookie file at path into the named collection.
+func seedFile(ctx context.Context, db *database.DB, path, collection string, offensive bool) error {
+	var (
+		r     io.Reader = os.Stdin
+		index *cookiefile.Index
+	)
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+
+		if index, err = readIndex(path + indexExt); err != nil {
+			return err
+		}
+		if index != nil && index.Rotated() {
+			offensive = true
+		}
+	}
+
+	res, err := frontend.Seed(ctx, db, collection, offensive, index, r)
+	if err != nil {
+		return fmt.Errorf("seeding %s: %w", path, err)
+	}
+	log.Printf("Seeded %s into %q (offensive=%t): %d inserted, %d duplicates, %d rejected, of %d",
+		path, collection, offensive, res.Inserted, res.Duplicates, res.Rejected, res.Total)
+	return nil
+}
+
+// readIndex reads the strfile index at path, or returns nil if there is
+// none.
+func readIndex(path string) (*cookiefile.Index, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	index, err := cookiefile.ReadIndex(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return index, nil
+}
+
+// writeIndex writes index to path.
+func writeIndex(path string, index *cookiefile.Index) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = index.WriteTo(f)
+	return errors.Join(err, f.Close())
+}
+
+// dump writes the fortunes of the database in the cookie file format, in id
+// order, to standard output or a file, or to a file per collection in a
+// directory, with the offensive fortunes in its "off" subdirectory. seed
+// loads such a directory back. Unless disabled, the strfile index of each
+// file is written next to it, as FILE.dat, so that the directory can be
+// read by the Unix fortune command; the offensive files are rot13-encoded
+// as it expects.
+func dump(ctx context.Context, cfg database.DBConfig, args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	out := fs.String("o", "", "file to write, instead of standard output")
+	dir := fs.String("out-dir", "", "directory to write a file per collection to, instead of a single file")
+	var filter frontend.DumpFilter
+	fs.StringVar(&filter.Collection, "collection", "", "only dump this collection")
+	offensive := fs.String("offensive", "include", "whether to dump offensive fortunes: exclude, include or only")
+	fs.Int64Var(&filter.MinID, "min-id", 0, "smallest id to dump")
+	fs.Int64Var(&filter.MaxID, "max-id", 0, "largest id to dump")
+	dat := fs.Bool("dat", true, "write the strfile index of each file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: db dump [flags]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return fmt.Errorf("dump: unexpected arguments %q", fs.Args())
+	}
+	if *out != "" && *dir != "" {
+		return errors.New("dump: -o and -out-dir are exclusive")
+	}
+	switch *offensive {
+	case "exclude":
+		filter.Offensive = cookieindex.ExcludeOffensive
+	case "include":
+		filter.Offensive = cookieindex.IncludeOffensive
+	case "only":
+		filter.Offensive = cookieindex.OnlyOffensive
+	default:
+		return fmt.Errorf("dump: invalid offensive mode %q", *offensive)
+	}
+
+	db, err := openDB(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	d := dumper{dat: *dat}
+	if *dir == "" {
+		w := os.Stdout
+		if *out != "" {
+			if w, err = os.Create(*out); err != nil {
+				return err
+			}
+		}
+		d.single = d.add(w, *out, false)
+	} else {
+		d.dir = *dir
+	}
+
+	var (
+		n     int
+		first frontend.DumpedFortune
+		mixed bool // whether a single file mixes collections or offensiveness
+	)
+	err = frontend.Dump(ctx, db, filter, func(f frontend.DumpedFortune) error {
+		if n == 0 {
+			first = f
+		} else if f.Collection != first.Collection || f.Offensive != first.Offensive {
+			mixed = true
+		}
+		w, err := d.writer(f)
+		if err != nil {
+			return err
+		}
+		if err := w.Write(f.Text); err != nil {
+			return fmt.Errorf("fortune %d: %w", f.ID, err)
+		}
+		n++
+		return nil
+	})
+	if cerr := d.close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Dumped %d fortunes", n)
+	if d.single != nil && mixed {
+		log.Printf("Warning: a single file does not record the collections of the fortunes, nor which are offensive; " +
+			"dump with -out-dir to seed them back as they were")
+	}
+	return nil
+}
+
+// dumper holds the files written by dump.
+type dumper struct {
+	single *cookiefile.Writer            // writer of all fortunes, if any
+	dir    string                        // directory of the files otherwise
+	dat    bool                          // whether to write the index of each file
+	byPath map[string]*cookiefile.Writer // files in dir by path
+	files  []dumpFile
+}
+
+// dumpFile is a file written by dump, other than standard output.
+type dumpFile struct {
+	*os.File
+	w *cookiefile.Writer
+}
+
+// writer returns the writer of the file of f.
+func (d *dumper) writer(f frontend.DumpedFortune) (*cookiefile.Writer, error) {
+	if d.single != nil {
+		return d.single, nil
+	}
+	dir := d.dir
+	if f.Offensive {
+		dir = filepath.Join(dir, offensiveDir)
+	}
+	path := filepath.Join(dir, f.Collection)
+	if w, ok := d.byPath[path]; ok {
+		return w, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if d.byPath == nil {
+		d.byPath = make(map[string]*cookiefile.Writer)
+	}
+	d.byPath[path] = d.add(file, path, f.Offensive && d.dat)
+	return d.byPath[path], nil
+}
+
+// add returns a writer of file, rot13-encoding its fortunes if rotated.
+// The file is closed by close unless it is standard output.
+func (d *dumper) add(file *os.File, path string, rotated bool) *cookiefile.Writer {
+	w := cookiefile.NewWriter(file)
+	if rotated {
+		w.SetRotated()
+	}
+	if path != "" {
+		d.files = append(d.files, dumpFile{file, w})
+	}
+	return w
+}
+
+// close flushes the writers and closes the files, writing their index if
+// requested.
+func (d *dumper) close() error {
+	var errs []error
+	if d.single != nil && len(d.files) == 0 {
+		errs = append(errs, d.single.Flush()) // standard output
+	}
+	for _, f := range d.files {
+		err := f.w.Flush()
+		if err == nil && d.dat {
+			err = writeIndex(f.Name()+indexExt, f.w.Index())
+		}
+		errs = append(errs, err, f.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -7,29 +7,37 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"strings"
 
 	"github.com/caarlos0/env"
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/mysql"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/tetsuo/fortune/internal/database"
 )
 
+var migrationsDir = flag.String("dir", "etc/migrations", "directory of the migration files created by new")
+
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "usage: db [cmd]\n")
-		fmt.Fprintf(flag.CommandLine.Output(), "  create: creates a new database. It does not run migrations\n")
-		fmt.Fprintf(flag.CommandLine.Output(), "  migrate: runs all migrations \n")
-		fmt.Fprintf(flag.CommandLine.Output(), "  drop: drops database\n")
-		fmt.Fprintf(flag.CommandLine.Output(), "  truncate: truncates all tables in database\n")
-		fmt.Fprintf(flag.CommandLine.Output(), "  recreate: drop, create and run migrations\n")
-		fmt.Fprintf(flag.CommandLine.Output(), "Database name is set using $DATABASE_NAME.\n")
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "usage: db [flags] cmd [arg]\n")
+		fmt.Fprintf(out, "  create: creates a new database. It does not run migrations\n")
+		fmt.Fprintf(out, "  migrate: runs all migrations \n")
+		fmt.Fprintf(out, "  drop: drops database\n")
+		fmt.Fprintf(out, "  truncate: truncates all tables in database\n")
+		fmt.Fprintf(out, "  recreate: drop, create and run migrations\n")
+		fmt.Fprintf(out, "  status: shows the schema version and the migrations applied or pending\n")
+		fmt.Fprintf(out, "  version: shows the schema version\n")
+		fmt.Fprintf(out, "  down N: rolls back the last N migrations\n")
+		fmt.Fprintf(out, "  goto V: migrates up or down to version V\n")
+		fmt.Fprintf(out, "  force V: sets the version to V without migrating, to repair a dirty schema; -1 means no migration\n")
+		fmt.Fprintf(out, "  new NAME: creates empty up and down migration files with the next version number\n")
+		fmt.Fprintf(out, "  seed [flags] FILE...: loads fortunes from cookie files, or directories written by dump -out-dir\n")
+		fmt.Fprintf(out, "  dump [flags]: writes fortunes in the cookie file format, in id order\n")
+		fmt.Fprintf(out, "The database is configured with $DATABASE_HOST, $DATABASE_PORT, $DATABASE_USER,\n")
+		fmt.Fprintf(out, "$DATABASE_PASSWORD and $DATABASE_NAME.\n")
 		flag.PrintDefaults()
 	}
 
 	flag.Parse()
-	if flag.NArg() != 1 {
+	if flag.NArg() < 1 {
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -39,100 +47,126 @@ func main() {
 		log.Fatal(err)
 	}
 
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?multiStatements=true&parseTime=true",
-		cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName)
-
-	if err := run(context.Background(), flag.Args()[0], cfg.DBName, dsn); err != nil {
+	if err := run(context.Background(), cfg, flag.Arg(0), flag.Args()[1:]); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func run(ctx context.Context, cmd, dbName, connectionInfo string) error {
+// commandArgs is the number of arguments of each command, if any, or -1
+// for the commands that parse their own flags and arguments.
+var commandArgs = map[string]int{
+	"down":  1,
+	"goto":  1,
+	"force": 1,
+	"new":   1,
+	"seed":  -1,
+	"dump":  -1,
+}
+
+func run(ctx context.Context, cfg database.DBConfig, cmd string, args []string) error {
+	if n := commandArgs[cmd]; n >= 0 && len(args) != n {
+		return fmt.Errorf("%s: got %d arguments, want %d", cmd, len(args), n)
+	}
 	switch cmd {
 	case "create":
-		return create(dbName)
+		return create(cfg)
 	case "migrate":
-		return migrateUp(connectionInfo)
+		return migrateUp(ctx, cfg)
 	case "drop":
-		return drop(dbName)
+		return drop(cfg)
 	case "recreate":
-		return recreate(dbName)
+		return recreate(ctx, cfg)
 	case "truncate":
-		return truncate(ctx, connectionInfo)
+		return truncate(ctx, cfg)
+	case "status":
+		return status(ctx, cfg)
+	case "version":
+		return version(ctx, cfg)
+	case "down":
+		return down(ctx, cfg, args[0])
+	case "goto":
+		return gotoVersion(ctx, cfg, args[0])
+	case "force":
+		return force(ctx, cfg, args[0])
+	case "new":
+		return newMigration(*migrationsDir, args[0])
+	case "seed":
+		return seed(ctx, cfg, args)
+	case "dump":
+		return dump(ctx, cfg, args)
 	default:
 		return fmt.Errorf("unsupported arg: %q", cmd)
 	}
 }
 
-func create(dbName string) error {
-	if err := database.CreateDBIfNotExists(dbName); err != nil {
-		if strings.Contains(err.Error(), "already exists") || strings.Contains(err.Error(), "database exists") {
-			log.Print(strings.TrimPrefix(err.Error(), "error creating "))
-			return nil
-		}
-		return err
-	}
-	log.Printf("Database created: %q", dbName)
-	return nil
-}
-
-func migrateUp(dsn string) error {
-	db, err := sql.Open("mysql", dsn)
+// openDB opens the database configured by cfg.
+func openDB(ctx context.Context, cfg database.DBConfig) (*database.DB, error) {
+	db, err := database.Open(ctx, "mysql", cfg.DSN(), "dbadmin")
 	if err != nil {
-		return fmt.Errorf("failed to open database connection: %w", err)
+		return nil, fmt.Errorf("failed to open database connection: %w", err)
 	}
-	defer db.Close()
+	return db, nil
+}
 
-	driver, err := mysql.WithInstance(db, &mysql.Config{})
-	if err != nil {
-		return fmt.Errorf("failed to create MySQL migration driver: %w", err)
-	}
+// dbExists reports whether the database configured by cfg exists.
+func dbExists(db *sql.DB, cfg database.DBConfig) (bool, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM INFORMATION_SCHEMA.SCHEMATA WHERE SCHEMA_NAME = ?`, cfg.DBName).Scan(&n)
+	return n > 0, err
+}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://etc/migrations",
-		"mysql",
-		driver,
-	)
+func create(cfg database.DBConfig) error {
+	name, err := database.QuoteDatabase(cfg.DBName)
 	if err != nil {
-		return fmt.Errorf("failed to create migrate instance: %w", err)
-	}
-
-	// Run migration
-	err = m.Up()
-	if err != nil && err != migrate.ErrNoChange {
-		return fmt.Errorf("migration failed: %w", err)
+		return err
 	}
-
-	log.Println("Database migration successful!")
-	return nil
+	return database.ConnectAndExecute(cfg.ServerDSN(), func(db *sql.DB) error {
+		if exists, err := dbExists(db, cfg); err != nil || exists {
+			if exists {
+				log.Printf("Database already exists: %q", cfg.DBName)
+			}
+			return err
+		}
+		if _, err := db.Exec(`CREATE DATABASE ` + name + ` CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci`); err != nil {
+			return fmt.Errorf("error creating %q: %v", cfg.DBName, err)
+		}
+		log.Printf("Database created: %q", cfg.DBName)
+		return nil
+	})
 }
 
-func drop(dbName string) error {
-	err := database.DropDB(dbName)
+func drop(cfg database.DBConfig) error {
+	name, err := database.QuoteDatabase(cfg.DBName)
 	if err != nil {
-		if strings.Contains(err.Error(), "Unknown database") {
-			log.Printf("Database does not exist: %q", dbName)
-			return nil
-		}
 		return err
 	}
-	log.Printf("Dropped database: %q", dbName)
-	return nil
+	return database.ConnectAndExecute(cfg.ServerDSN(), func(db *sql.DB) error {
+		if exists, err := dbExists(db, cfg); err != nil || !exists {
+			if err == nil {
+				log.Printf("Database does not exist: %q", cfg.DBName)
+			}
+			return err
+		}
+		if _, err := db.Exec(`DROP DATABASE ` + name); err != nil {
+			return fmt.Errorf("error dropping %q: %v", cfg.DBName, err)
+		}
+		log.Printf("Dropped database: %q", cfg.DBName)
+		return nil
+	})
 }
 
-func recreate(dbName string) error {
-	if err := drop(dbName); err != nil {
+func recreate(ctx context.Context, cfg database.DBConfig) error {
+	if err := drop(cfg); err != nil {
 		return err
 	}
-	if err := database.CreateDB(dbName); err != nil {
+	if err := create(cfg); err != nil {
 		return err
 	}
-	return migrateUp(fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?multiStatements=true&parseTime=true",
-		"root", "example", "127.0.0.1", "3306", dbName))
+	return migrateUp(ctx, cfg)
 }
 
-func truncate(ctx context.Context, connectionInfo string) error {
-	db, err := database.Open("mysql", connectionInfo, "dbadmin")
+func truncate(ctx context.Context, cfg database.DBConfig) error {
+	db, err := openDB(ctx, cfg)
 	if err != nil {
 		log.Printf("Error opening database: %v", err)
 		return err
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/tetsuo/fortune/etc/migrations"
+	"github.com/tetsuo/fortune/internal/database"
+)
+
+// lockTimeout is the longest to wait for a frontend server or another
+// command to finish migrating.
+const lockTimeout = time.Minute
+
+func migrateUp(ctx context.Context, cfg database.DBConfig) error {
+	db, err := openDB(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	version, err := db.Migrate(ctx, migrations.FS, lockTimeout)
+	if err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+
+	log.Printf("Database migration successful, at version %d", version)
+	return nil
+}
+
+// runMigrate calls f with a golang-migrate instance for the embedded
+// migrations and the database configured by cfg, then logs the resulting
+// version.
+func runMigrate(ctx context.Context, cfg database.DBConfig, f func(*migrate.Migrate) error) error {
+	db, err := openDB(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = db.RunMigrate(ctx, migrations.FS, lockTimeout, func(m *migrate.Migrate) error {
+		if err := f(m); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return version(ctx, cfg)
+}
+
+func status(ctx context.Context, cfg database.DBConfig) error {
+	db, err := openDB(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	state, err := db.CheckSchema(ctx, migrations.FS)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("database %s on %s:%s is at %s\n", cfg.DBName, cfg.DBHost, cfg.DBPort, state)
+
+	src, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	for v, err := src.First(); !errors.Is(err, fs.ErrNotExist); v, err = src.Next(v) {
+		if err != nil {
+			return err
+		}
+		r, name, err := src.ReadUp(v)
+		if err != nil {
+			return err
+		}
+		r.Close()
+
+		mark := "pending"
+		switch {
+		case v == state.Version && state.Dirty:
+			mark = "dirty"
+		case v <= state.Version:
+			mark = "applied"
+		}
+		fmt.Printf("  %-7s  %06d_%s\n", mark, v, name)
+	}
+	return nil
+}
+
+func version(ctx context.Context, cfg database.DBConfig) error {
+	db, err := openDB(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	version, dirty, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		fmt.Printf("%d (dirty)\n", version)
+	} else {
+		fmt.Printf("%d\n", version)
+	}
+	return nil
+}
+
+func down(ctx context.Context, cfg database.DBConfig, arg string) error {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("down: invalid number of migrations %q", arg)
+	}
+	return runMigrate(ctx, cfg, func(m *migrate.Migrate) error {
+		return m.Steps(-n)
+	})
+}
+
+func gotoVersion(ctx context.Context, cfg database.DBConfig, arg string) error {
+	v, err := strconv.ParseUint(arg, 10, 32)
+	if err != nil {
+		return fmt.Errorf("goto: invalid version %q", arg)
+	}
+	return runMigrate(ctx, cfg, func(m *migrate.Migrate) error {
+		return m.Migrate(uint(v))
+	})
+}
+
+func force(ctx context.Context, cfg database.DBConfig, arg string) error {
+	v, err := strconv.Atoi(arg)
+	if err != nil || v < -1 {
+		return fmt.Errorf("force: invalid version %q", arg)
+	}
+	return runMigrate(ctx, cfg, func(m *migrate.Migrate) error {
+		return m.Force(v)
+	})
+}
+
+// migrationNameRegexp matches valid names of new migrations.
+var migrationNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// newMigration creates the empty up and down files of a migration named
+// name in dir, numbered after the last one.
+func newMigration(dir, name string) error {
+	if !migrationNameRegexp.MatchString(name) {
+		return fmt.Errorf("new: invalid migration name %q, want lowercase letters, digits and underscores", name)
+	}
+	latest, err := database.LatestVersion(os.DirFS(dir))
+	if err != nil {
+		return err
+	}
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%06d_%s.%s.sql", latest+1, name, direction))
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		log.Printf("Created %s", path)
+	}
+	return nil
+}
@@ -0,0 +1,2 @@
+ALTER TABLE fortune_cookies
+    ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
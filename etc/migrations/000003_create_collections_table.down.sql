@@ -0,0 +1,7 @@
+ALTER TABLE fortune_cookies
+    DROP FOREIGN KEY fortune_cookies_collection_id_fkey;
+
+ALTER TABLE fortune_cookies
+    DROP COLUMN collection_id;
+
+DROP TABLE IF EXISTS collections;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS collections (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY collections_name_key (name)
+);
+
+-- Existing fortunes are moved to the default collection.
+INSERT INTO collections (id, name)
+SELECT 1, 'default' FROM DUAL
+WHERE EXISTS (SELECT 1 FROM fortune_cookies);
+
+ALTER TABLE fortune_cookies
+    ADD COLUMN collection_id INT NOT NULL DEFAULT 1,
+    ADD CONSTRAINT fortune_cookies_collection_id_fkey
+        FOREIGN KEY (collection_id) REFERENCES collections (id);
+
+ALTER TABLE fortune_cookies
+    ALTER COLUMN collection_id DROP DEFAULT;
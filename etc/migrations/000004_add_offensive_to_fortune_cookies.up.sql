@@ -0,0 +1,4 @@
+-- Offensive fortunes are stored rot13-encoded, like the off/ cookie files of
+-- the Unix fortune command.
+ALTER TABLE fortune_cookies
+    ADD COLUMN offensive BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+ALTER TABLE fortune_cookies
+    DROP COLUMN length;
@@ -0,0 +1,10 @@
+ALTER TABLE fortune_cookies
+    ADD COLUMN length INT NOT NULL DEFAULT 0;
+
+-- Lengths are in bytes, like those reported by strfile. Since rot13 maps
+-- letters to letters, offensive fortunes have the same length encoded.
+UPDATE fortune_cookies SET length = LENGTH(value);
+
+-- From now on the length is set by whoever inserts a fortune.
+ALTER TABLE fortune_cookies
+    ALTER COLUMN length DROP DEFAULT;
@@ -0,0 +1,2 @@
+ALTER TABLE fortune_cookies
+    DROP INDEX fortune_cookies_value_idx;
@@ -0,0 +1,7 @@
+-- MySQL may have dropped the index it created for the foreign key of
+-- collection_id, which the unique key can enforce, so the foreign key is
+-- given an index of its own first.
+ALTER TABLE fortune_cookies
+    ADD INDEX fortune_cookies_collection_id_idx (collection_id),
+    DROP INDEX fortune_cookies_collection_content_hash_key,
+    DROP COLUMN content_hash;
@@ -0,0 +1,19 @@
+ALTER TABLE fortune_cookies
+    ADD COLUMN content_hash BINARY(32);
+
+-- The hash is the SHA-256 digest of the stored, possibly rot13-encoded,
+-- value.
+UPDATE fortune_cookies SET content_hash = UNHEX(SHA2(value, 256));
+
+-- Only the first copy of each fortune in a collection is kept, since
+-- uploads used to store them again.
+DELETE f FROM fortune_cookies f
+JOIN fortune_cookies g
+    ON g.collection_id = f.collection_id
+    AND g.content_hash = f.content_hash
+    AND g.id < f.id;
+
+-- A fortune is stored at most once per collection.
+ALTER TABLE fortune_cookies
+    MODIFY COLUMN content_hash BINARY(32) NOT NULL,
+    ADD UNIQUE KEY fortune_cookies_collection_content_hash_key (collection_id, content_hash);
@@ -0,0 +1,10 @@
+// Package migrations embeds the migrations of the fortune database, so that
+// binaries can apply them without access to this directory.
+package migrations
+
+import "embed"
+
+// FS holds the migration files, in the format of golang-migrate.
+//
+//go:embed *.sql
+var FS embed.FS
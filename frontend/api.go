@@ -2,31 +2,57 @@ package frontend
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"mime"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
+	"unicode/utf8"
+
+	"github.com/tetsuo/fortune/internal/cookiefile"
+	"github.com/tetsuo/fortune/internal/cookieindex"
+	"github.com/tetsuo/fortune/internal/database"
 )
 
-// servePOST handles HTTP POST requests to insert new fortune messages.
-// It validates the request content type, enforces a maximum body size,
-// and parses the input using decodeBody. The parsed values are then
-// inserted into the database in bulk. Returns an error if validation,
+// servePOST handles HTTP POST requests to insert new fortune messages into
+// the collection named in the request path, or the default collection. It
+// validates the request content type, enforces a maximum body size, and
+// parses the input as it arrives using decodeBody. A multipart body may send
+// the strfile index of the cookie file along with it, which the file must
+// match; see multipartUpload. The parsed values are inserted into the
+// database in batches, rot13-encoded if the request marks them as offensive,
+// so that bodies of any size take the same amount of memory. Fortunes that
+// are already stored in the collection, or repeated in the body, are skipped
+// and counted in the X-Duplicate-Count header. If the request asks for it,
+// the response lists the rejected entries of the body along with the reason
+// for rejecting each; see uploadReport. Returns an error if validation,
 // parsing, or database insertion fails.
 func (s *Server) servePOST(w http.ResponseWriter, r *http.Request) error {
-	if r.URL.Path != "/" {
+	name := r.PathValue("collection")
+	if name == "" {
+		if r.URL.Path != "/" {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return nil
+		}
+		name = defaultCollection
+	} else if !validCollectionName(name) {
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return nil
 	}
 
+	if s.readOnly.Load() {
+		return errReadOnly
+	}
+
 	ct := r.Header.Get("Content-Type")
 
-	mediaType, _, err := mime.ParseMediaType(ct)
-	if err != nil || mediaType != "text/plain" {
+	mediaType, params, err := mime.ParseMediaType(ct)
+	if err != nil || (mediaType != "text/plain" && mediaType != "multipart/form-data") {
 		return &serverError{
 			status:       http.StatusUnsupportedMediaType,
 			responseText: http.StatusText(http.StatusUnsupportedMediaType),
@@ -34,9 +60,90 @@ func (s *Server) servePOST(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
-	const maxBodySize = 1 << 20 // 1 MB in bytes
+	offensive, err := uploadIsOffensive(r)
+	if err != nil {
+		return &serverError{
+			status:       http.StatusBadRequest,
+			responseText: http.StatusText(http.StatusBadRequest),
+			err:          err,
+		}
+	}
 
-	values, err := decodeBody(http.MaxBytesReader(w, r.Body, maxBodySize))
+	report, err := wantsUploadReport(r)
+	if err != nil {
+		return &serverError{
+			status:       http.StatusBadRequest,
+			responseText: http.StatusText(http.StatusBadRequest),
+			err:          err,
+		}
+	}
+
+	atomic, err := uploadIsAtomic(r)
+	if err != nil {
+		return &serverError{
+			status:       http.StatusBadRequest,
+			responseText: http.StatusText(http.StatusBadRequest),
+			err:          err,
+		}
+	}
+
+	if r.ContentLength > s.maxUploadSize {
+		return &serverError{
+			status:       http.StatusRequestEntityTooLarge,
+			responseText: http.StatusText(http.StatusRequestEntityTooLarge),
+			err:          &http.MaxBytesError{Limit: s.maxUploadSize},
+		}
+	}
+
+	body := io.Reader(http.MaxBytesReader(w, r.Body, s.maxUploadSize))
+	var index *cookiefile.Index
+	if mediaType == "multipart/form-data" {
+		if index, body, err = multipartUpload(body, params["boundary"]); err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				return &serverError{
+					status:       http.StatusRequestEntityTooLarge,
+					responseText: http.StatusText(http.StatusRequestEntityTooLarge),
+					err:          maxErr,
+				}
+			}
+			return &serverError{
+				status:       http.StatusBadRequest,
+				responseText: http.StatusText(http.StatusBadRequest),
+				err:          err,
+			}
+		}
+	}
+	if index != nil && !atomic {
+		// The count of the index is only checked once all the entries
+		// are parsed, when some of them would already be kept.
+		return &serverError{
+			status:       http.StatusBadRequest,
+			responseText: http.StatusText(http.StatusBadRequest),
+			err:          errors.New("an upload with an index must be atomic"),
+		}
+	}
+
+	// Large uploads take a while, so the time is only bounded by the
+	// request timeout. Reads see the batches inserted before them.
+	ctx := database.WithPrimary(r.Context())
+
+	u := &upload{collection: name, offensive: offensive, report: report, index: index}
+
+	insert := func(db *database.DB) error {
+		return u.run(ctx, db, body)
+	}
+	if atomic {
+		// Read committed takes no gap locks, so concurrent uploads don't
+		// block each other unless they insert the same fortunes.
+		err = s.db.Transact(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted}, insert)
+	} else {
+		// The batches inserted before an error are kept.
+		err = insert(s.db)
+	}
+	if u.inserted > 0 && (err == nil || !atomic) {
+		s.invalidateCookieIndex()
+	}
 	if err != nil {
 		var maxErr *http.MaxBytesError
 		if errors.As(err, &maxErr) {
@@ -46,56 +153,96 @@ func (s *Server) servePOST(w http.ResponseWriter, r *http.Request) error {
 				err:          maxErr,
 			}
 		}
+		if errors.Is(err, errIndexMismatch) {
+			return &serverError{
+				status:       http.StatusBadRequest,
+				responseText: http.StatusText(http.StatusBadRequest),
+				err:          err,
+			}
+		}
 		// Other errors
 		return err
 	}
 
-	insertCount := len(values)
-
-	if insertCount < 1 {
+	if u.valid < 1 {
+		if report && u.total > 0 {
+			return writeUploadReport(w, http.StatusBadRequest, u)
+		}
 		return &serverError{
 			status:       http.StatusBadRequest,
 			responseText: http.StatusText(http.StatusBadRequest),
 		}
 	}
 
-	w.Header().Set("X-Inserted-Count", strconv.Itoa(insertCount))
+	w.Header().Set("X-Inserted-Count", strconv.FormatInt(u.inserted, 10))
+	w.Header().Set("X-Duplicate-Count", strconv.FormatInt(u.duplicates, 10))
 
-	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
-	defer cancel()
+	status := http.StatusCreated
+	if u.inserted == 0 {
+		status = http.StatusOK
+	}
 
-	if err = s.db.BulkInsert(ctx, "fortune_cookies", []string{"value"}, values, ""); err != nil {
-		return err
+	if report {
+		return writeUploadReport(w, status, u)
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(status)
 
 	return nil
 }
 
-// serveGET handles HTTP GET requests to retrieve a random fortune message.
-// It selects a random entry from the database and returns it as a plain
-// text response. Returns an error if querying the database fails.
+// fortune is a single fortune message as returned by the GET handlers.
+type fortune struct {
+	ID         int64     `json:"id"`
+	Text       string    `json:"text"`
+	Length     int       `json:"length"` // in bytes
+	Collection string    `json:"collection"`
+	Offensive  bool      `json:"offensive"`
+	CreatedAt  time.Time `json:"created"`
+}
+
+// fortuneContentTypes lists the representations of a fortune, in order of
+// preference.
+var fortuneContentTypes = []string{"text/plain", "application/json"}
+
+// serveGET handles HTTP GET requests to retrieve a random fortune message
+// from the collection named in the request path, or from all collections.
+// It picks an entry uniformly at random among those selected by the
+// offensive and length query parameters of the request, and returns
+// it as plain text or JSON, depending on the Accept header of the request,
+// along with headers that identify the chosen entry and its collection.
+// Returns an error if querying the database fails.
 func (s *Server) serveGET(w http.ResponseWriter, r *http.Request) error {
-	if r.URL.Path != "/" {
+	name := r.PathValue("collection")
+	if name == "" && r.URL.Path != "/" {
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return nil
 	}
 
+	contentType := negotiateContentType(r, fortuneContentTypes...)
+	if contentType == "" {
+		return &serverError{
+			status:       http.StatusNotAcceptable,
+			responseText: http.StatusText(http.StatusNotAcceptable),
+		}
+	}
+
+	filter, err := s.fortuneFilter(r)
+	if err != nil {
+		return &serverError{
+			status:       http.StatusBadRequest,
+			responseText: http.StatusText(http.StatusBadRequest),
+			err:          err,
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
-	var message []byte
-
-	err := s.db.QueryRow(ctx, `SELECT value
-FROM fortune_cookies
-WHERE id >= (
-   SELECT FLOOR( RAND() * (SELECT MAX(id) FROM fortune_cookies) ) + 1
-)
-ORDER BY id
-LIMIT 1`).Scan(&message)
+	f, err := s.randomFortune(ctx, name, filter)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		var cerr *collectionNotFoundError
+		if errors.Is(err, sql.ErrNoRows) || errors.As(err, &cerr) {
 			return &serverError{
 				status:       http.StatusNotFound,
 				responseText: http.StatusText(http.StatusNotFound),
@@ -106,65 +253,176 @@ LIMIT 1`).Scan(&message)
 		return err
 	}
 
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	return writeFortune(w, contentType, f)
+}
 
-	w.WriteHeader(http.StatusOK)
+// serveFortune handles HTTP GET requests to retrieve the fortune message
+// with the id given in the request path. Returns a 404 error if the id is
+// malformed or no such fortune exists.
+func (s *Server) serveFortune(w http.ResponseWriter, r *http.Request) error {
+	contentType := negotiateContentType(r, fortuneContentTypes...)
+	if contentType == "" {
+		return &serverError{
+			status:       http.StatusNotAcceptable,
+			responseText: http.StatusText(http.StatusNotAcceptable),
+		}
+	}
 
-	_, err = w.Write(message)
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		return &serverError{
+			status:       http.StatusNotFound,
+			responseText: http.StatusText(http.StatusNotFound),
+			err:          err,
+		}
+	}
 
-	return err
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	f, err := s.fortuneByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &serverError{
+				status:       http.StatusNotFound,
+				responseText: http.StatusText(http.StatusNotFound),
+				err:          err,
+			}
+		}
+		// Other errors
+		return err
+	}
+
+	return writeFortune(w, contentType, f)
 }
 
-// decodeBody parses the fortune format from a request body, splitting messages by '%'
-// and trimming whitespace. It filters out invalid lengths and returns a slice of any
-// since BulkInsert requires it. Returns an error if reading fails.
-func decodeBody(r io.Reader) ([]any, error) {
-	const (
-		minCookieLength = 3
-		maxCookieLength = 10000
-	)
+// randomFortune returns a fortune chosen uniformly at random among those
+// matching filter in the named collection, or in all collections if name is
+// empty. It returns sql.ErrNoRows if there are no such fortunes, and a
+// *collectionNotFoundError if the collection does not exist.
+func (s *Server) randomFortune(ctx context.Context, name string, filter cookieindex.Filter) (*fortune, error) {
+	if name != "" && !validCollectionName(name) {
+		return nil, &collectionNotFoundError{name: name}
+	}
+	li, err := s.cookieIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		c, ok := li.collection(name)
+		if !ok {
+			return nil, &collectionNotFoundError{name: name}
+		}
+		filter.Collection = c.ID
+	}
+	id, ok := li.Pick(nil, filter)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	f, err := s.fortuneByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The index may have been loaded from the primary, or from another
+		// read replica, which this one lags behind.
+		f, err = s.fortuneByID(database.WithPrimary(ctx), id)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		// The fortune was deleted after the index was loaded.
+		s.invalidateCookieIndex()
+	}
+	return f, err
+}
 
-	data, err := io.ReadAll(r)
+// fortuneByID returns the fortune with the given id, decoding its text if
+// it is offensive. It returns sql.ErrNoRows if there is no such fortune.
+func (s *Server) fortuneByID(ctx context.Context, id int64) (*fortune, error) {
+	f := &fortune{ID: id}
+	err := s.db.QueryRow(ctx, `SELECT f.value, f.offensive, f.created_at, c.name
+FROM fortune_cookies f
+JOIN collections c ON c.id = f.collection_id
+WHERE f.id = ?`, id).Scan(&f.Text, &f.Offensive, &f.CreatedAt, &f.Collection)
 	if err != nil {
 		return nil, err
 	}
+	if f.Offensive {
+		f.Text = cookiefile.Rot13(f.Text)
+	}
+	return f, nil
+}
+
+// writeFortune writes a fortune message in the given representation. The
+// X-Fortune-Id and Link headers let clients cite or re-fetch the message
+// through its permalink, and X-Fortune-Collection names its source
+// collection, like "fortune -c" does.
+func writeFortune(w http.ResponseWriter, contentType string, f *fortune) error {
+	f.Length = len(f.Text)
+
+	var body []byte
+	switch contentType {
+	case "application/json":
+		var err error
+		if body, err = json.Marshal(f); err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+	default:
+		body = []byte(f.Text)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("X-Fortune-Id", strconv.FormatInt(f.ID, 10))
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="canonical"`, fortunePath(f.ID)))
+	w.Header().Set("X-Fortune-Collection", f.Collection)
+	w.Header().Add("Vary", "Accept")
 
-	input := string(data)
-	var cookies []any
+	w.WriteHeader(http.StatusOK)
 
-	// Split input into lines first
-	lines := strings.Split(input, "\n")
+	_, err := w.Write(body)
 
-	var currentBlock []string
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
+	return err
+}
 
-		// If a line contains only '%', it means we reached a separator.
-		if trimmed == "%" {
-			if len(currentBlock) > 0 {
-				cookie := strings.TrimSpace(strings.Join(currentBlock, "\n"))
+// fortunePath returns the permalink path of the fortune with the given id.
+func fortunePath(id int64) string {
+	return "/fortunes/" + strconv.FormatInt(id, 10)
+}
 
-				length := len(cookie)
-				if length >= minCookieLength && length <= maxCookieLength {
-					cookies = append(cookies, any(cookie))
-				}
+// cookieEntry is a fortune message parsed from a request body.
+type cookieEntry struct {
+	index  int               // index of the entry in the body, from 0
+	line   int               // line number of the first line of the entry, from 1
+	text   string            // trimmed text, rot13-encoded if offensive
+	hash   [sha256.Size]byte // SHA-256 digest of text
+	reason rejectReason      // why the entry was rejected, if it was
+}
 
-				currentBlock = nil
-			}
-			continue
-		}
+// decodeBody parses the fortune format from a request body, splitting messages by '%'
+// and trimming whitespace, and calls f for each message as soon as it is read. Blank
+// messages are skipped, unless strfile is set to split and count them as strfile does,
+// and the entries with invalid lengths or text are marked as rejected. Returns an error
+// if reading fails or f returns one.
+func decodeBody(r io.Reader, strfile bool, f func(*cookieEntry) error) error {
+	const (
+		minCookieLength = 3
+		maxCookieLength = 10000
+	)
 
-		currentBlock = append(currentBlock, line)
+	sc := cookiefile.NewScanner(r, maxCookieLength)
+	if strfile {
+		sc.SetStrfile()
 	}
-
-	// Handle last block (if there's no trailing '%')
-	if len(currentBlock) > 0 {
-		cookie := strings.TrimSpace(strings.Join(currentBlock, "\n"))
-		length := len(cookie)
-		if length >= minCookieLength && length <= maxCookieLength {
-			cookies = append(cookies, any(cookie))
+	for sc.Scan() {
+		c := sc.Cookie()
+		e := &cookieEntry{index: c.Index, line: c.Line, text: c.Text}
+		switch {
+		case c.Length < minCookieLength:
+			e.reason = rejectTooShort
+		case c.Length > maxCookieLength:
+			e.reason = rejectTooLong
+		case !utf8.ValidString(c.Text):
+			e.reason = rejectInvalidUTF8
+		}
+		if err := f(e); err != nil {
+			return err
 		}
 	}
-
-	return cookies, nil
+	return sc.Err()
 }
@@ -1,9 +1,21 @@
 package frontend
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tetsuo/fortune/internal/cookiefile"
 )
 
 func TestPOST(t *testing.T) {
@@ -12,7 +24,8 @@ func TestPOST(t *testing.T) {
 	testDB, release := acquire(t)
 	defer release()
 
-	_, handler, observedLogs := newTestServer(t, testDB)
+	s, handler, observedLogs := newTestServer(t, testDB)
+	s.maxUploadSize = 1 << 20
 
 	for _, tt := range []ttest{
 		{
@@ -54,6 +67,21 @@ func TestPOST(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:        "invalid atomic flag",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/?atomic=maybe",
+			body:        []byte("hoi"),
+			wantStatus:  http.StatusBadRequest,
+			wantText:    "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`400 invalid atomic flag "maybe"`,
+				},
+			},
+		},
 		{
 			name:        "empty request",
 			method:      "POST",
@@ -141,7 +169,7 @@ func TestPOST(t *testing.T) {
 	}
 }
 
-func TestGET(t *testing.T) {
+func TestLargeUpload(t *testing.T) {
 	t.Parallel()
 
 	testDB, release := acquire(t)
@@ -149,41 +177,226 @@ func TestGET(t *testing.T) {
 
 	_, handler, observedLogs := newTestServer(t, testDB)
 
-	expectedFortune := `"bazbar
-ququux"
-		―  Romantic Hacker`
+	// More than 1 MB, in several batches, with duplicates of the first
+	// batch at the end.
+	var b strings.Builder
+	for i := range 1010 {
+		fmt.Fprintf(&b, "Fortune #%d\n%s\n%%\n", i%1000, strings.Repeat("-", 1100))
+	}
+
+	rejections := make([]any, 10)
+	for i := range rejections {
+		rejections[i] = map[string]any{"block": float64(1000 + i), "line": float64(3*(1000+i) + 1), "reason": "duplicate"}
+	}
+
+	tt := ttest{
+		name:        "large upload",
+		method:      "POST",
+		contentType: "text/plain",
+		path:        "/?report=1",
+		body:        []byte(b.String()),
+		wantStatus:  http.StatusCreated,
+		wantHeaders: map[string][]string{
+			"X-Inserted-Count":  {"1000"},
+			"X-Duplicate-Count": {"10"},
+		},
+		wantJSON: map[string]any{
+			"total":      float64(1010),
+			"inserted":   float64(1000),
+			"rejections": rejections,
+		},
+	}
+	tt.run(t, handler, observedLogs)
+}
+
+func TestAtomicUpload(t *testing.T) {
+	t.Parallel()
+
+	testDB, release := acquire(t)
+	defer release()
+
+	s, handler, _ := newTestServer(t, testDB)
+	s.maxUploadSize = 512 << 10
+
+	// Several batches, more than the maximum upload size.
+	var b strings.Builder
+	for i := range 1000 {
+		fmt.Fprintf(&b, "Fortune #%d\n%s\n%%\n", i, strings.Repeat("-", 1100))
+	}
+
+	for _, test := range []struct {
+		name       string
+		collection string
+		query      string
+		wantStored bool
+	}{
+		{name: "atomic", collection: "atomic", wantStored: false},
+		{name: "best effort", collection: "partial", query: "?atomic=0", wantStored: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/"+test.collection+test.query, strings.NewReader(b.String()))
+			r.Header.Set("Content-Type", "text/plain")
+			// The size is only found to be too large while reading.
+			r.ContentLength = -1
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+			var n int
+			err := testDB.QueryRow(context.Background(), `SELECT COUNT(*) FROM fortune_cookies f
+JOIN collections c ON c.id = f.collection_id WHERE c.name = ?`, test.collection).Scan(&n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := n > 0; got != test.wantStored {
+				t.Errorf("%d fortunes stored, want stored = %t", n, test.wantStored)
+			}
+		})
+	}
+}
+
+func TestUploadIndex(t *testing.T) {
+	t.Parallel()
+
+	testDB, release := acquire(t)
+	defer release()
+
+	_, handler, observedLogs := newTestServer(t, testDB)
+
+	file, dat := cookieFile(t, false, "An indexed fortune.", "Another indexed fortune.")
+	rotFile, rotDat := cookieFile(t, true, "A rotated fortune.")
 
 	for _, tt := range []ttest{
 		{
-			name:       "no rows in the resultset",
-			method:     "GET",
-			path:       "/",
-			wantStatus: http.StatusNotFound,
-			wantText:   "Not Found\n",
+			name:        "upload with an index",
+			method:      "POST",
+			contentType: "multipart/form-data; boundary=" + testBoundary,
+			path:        "/indexed",
+			body:        multipartBody(t, "dat", dat, "cookies", file),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count": {"2"},
+			},
+		},
+		{
+			name:        "upload without an index",
+			method:      "POST",
+			contentType: "multipart/form-data; boundary=" + testBoundary,
+			path:        "/indexed",
+			body:        multipartBody(t, "cookies", "An unindexed fortune."),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count": {"1"},
+			},
+		},
+		{
+			name:        "count mismatch",
+			method:      "POST",
+			contentType: "multipart/form-data; boundary=" + testBoundary,
+			path:        "/indexed",
+			body:        multipartBody(t, "dat", dat, "cookies", file+"A fortune too many.\n"),
+			wantStatus:  http.StatusBadRequest,
+			wantText:    "Bad Request\n",
 			wantLogs: []wantedLog{
 				{
 					"info",
-					`404 sql: no rows in result set`,
+					"400 Transact(Read Committed): txFunc(tx): cookie file does not match its index: 3 entries, index has 2",
 				},
 			},
 		},
 		{
-			name:        "insert a fortune to get",
+			name:        "rotated index of inoffensive fortunes",
 			method:      "POST",
-			contentType: "text/plain",
-			path:        "/",
-			body:        []byte(expectedFortune),
+			contentType: "multipart/form-data; boundary=" + testBoundary,
+			path:        "/rotated",
+			body:        multipartBody(t, "dat", rotDat, "cookies", rotFile),
+			wantStatus:  http.StatusBadRequest,
+			wantText:    "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					"400 Transact(Read Committed): txFunc(tx): cookie file does not match its index: rotated index for inoffensive fortunes",
+				},
+			},
+		},
+		{
+			name:        "rotated index of offensive fortunes",
+			method:      "POST",
+			contentType: "multipart/form-data; boundary=" + testBoundary,
+			path:        "/rotated?offensive=1",
+			body:        multipartBody(t, "dat", rotDat, "cookies", rotFile),
 			wantStatus:  http.StatusCreated,
 			wantHeaders: map[string][]string{
 				"X-Inserted-Count": {"1"},
 			},
 		},
 		{
-			name:       "get a fortune",
+			name:       "rotated fortunes are not encoded twice",
 			method:     "GET",
-			path:       "/",
+			path:       "/rotated?offensive=only",
 			wantStatus: http.StatusOK,
-			wantText:   expectedFortune,
+			wantText:   "A rotated fortune.",
+		},
+		{
+			name:        "index of a best effort upload",
+			method:      "POST",
+			contentType: "multipart/form-data; boundary=" + testBoundary,
+			path:        "/indexed?atomic=0",
+			body:        multipartBody(t, "dat", dat, "cookies", file),
+			wantStatus:  http.StatusBadRequest,
+			wantText:    "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					"400 an upload with an index must be atomic",
+				},
+			},
+		},
+		{
+			name:        "invalid index",
+			method:      "POST",
+			contentType: "multipart/form-data; boundary=" + testBoundary,
+			path:        "/indexed",
+			body:        multipartBody(t, "dat", "short", "cookies", file),
+			wantStatus:  http.StatusBadRequest,
+			wantText:    "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					"400 cookiefile: reading index header: unexpected EOF",
+				},
+			},
+		},
+		{
+			name:        "unexpected part",
+			method:      "POST",
+			contentType: "multipart/form-data; boundary=" + testBoundary,
+			path:        "/indexed",
+			body:        multipartBody(t, "fortunes", file),
+			wantStatus:  http.StatusBadRequest,
+			wantText:    "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`400 unexpected part "fortunes" in multipart upload`,
+				},
+			},
+		},
+		{
+			name:        "no cookies part",
+			method:      "POST",
+			contentType: "multipart/form-data; boundary=" + testBoundary,
+			path:        "/indexed",
+			body:        multipartBody(t, "dat", dat),
+			wantStatus:  http.StatusBadRequest,
+			wantText:    "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					"400 multipart upload has no cookies part",
+				},
+			},
 		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
@@ -192,45 +405,268 @@ ququux"
 	}
 }
 
-func TestNotFound(t *testing.T) {
+// testBoundary is the boundary of the bodies returned by multipartBody.
+const testBoundary = "fortune-test-boundary"
+
+// multipartBody returns a multipart/form-data body made of the given parts,
+// as pairs of names and contents.
+func multipartBody(t *testing.T, parts ...string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.SetBoundary(testBoundary); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(parts); i += 2 {
+		pw, err := mw.CreateFormFile(parts[i], parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(pw, parts[i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// cookieFile returns a cookie file of texts along with its strfile index.
+func cookieFile(t *testing.T, rotated bool, texts ...string) (file, dat string) {
+	t.Helper()
+
+	var fb, db strings.Builder
+	w := cookiefile.NewWriter(&fb)
+	if rotated {
+		w.SetRotated()
+	}
+	for _, text := range texts {
+		if err := w.Write(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Index().WriteTo(&db); err != nil {
+		t.Fatal(err)
+	}
+	return fb.String(), db.String()
+}
+
+func TestDecodeBody(t *testing.T) {
+	var got []*cookieEntry
+	err := decodeBody(strings.NewReader("\n  first\n  line\n%\n%\n   \n%\nab\n%\nlast\n"), false, func(e *cookieEntry) error {
+		got = append(got, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*cookieEntry{
+		{index: 0, line: 2, text: "first\n  line"},
+		{index: 1, line: 8, text: "ab", reason: rejectTooShort},
+		{index: 2, line: 10, text: "last"},
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestDuplicates(t *testing.T) {
 	t.Parallel()
 
-	_, handler, observedLogs := newTestServer(t, nil)
+	testDB, release := acquire(t)
+	defer release()
+
+	_, handler, observedLogs := newTestServer(t, testDB)
 
 	for _, tt := range []ttest{
 		{
-			name:       "invalid GET path",
-			method:     "GET",
-			path:       "/notmuch",
-			wantStatus: http.StatusNotFound,
-			wantText:   "Not Found\n",
+			name:        "duplicates within a batch",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/",
+			body:        []byte("one\n%\ntwo\n%\n  one\n"),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count":  {"2"},
+				"X-Duplicate-Count": {"1"},
+			},
 		},
 		{
-			name:       "invalid POST path",
-			method:     "POST",
-			path:       "/notmuch",
-			wantStatus: http.StatusNotFound,
-			wantText:   "Not Found\n",
+			name:        "duplicates of stored fortunes",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/",
+			body:        []byte("two\n%\nthree"),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count":  {"1"},
+				"X-Duplicate-Count": {"1"},
+			},
+		},
+		{
+			name:        "duplicates only",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/",
+			body:        []byte("three\n%\none"),
+			wantStatus:  http.StatusOK,
+			wantEmpty:   true,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count":  {"0"},
+				"X-Duplicate-Count": {"2"},
+			},
+		},
+		{
+			name:        "same fortune in another collection",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/art",
+			body:        []byte("one"),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count":  {"1"},
+				"X-Duplicate-Count": {"0"},
+			},
+		},
+		{
+			name:        "offensive fortunes are stored encoded",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/?offensive=1",
+			body:        []byte("one"),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count":  {"1"},
+				"X-Duplicate-Count": {"0"},
+			},
 		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.run(t, handler, observedLogs)
 		})
 	}
+
+	var count int
+	if err := testDB.QueryRow(context.Background(), `SELECT COUNT(*) FROM fortune_cookies`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 {
+		t.Errorf("got %d fortunes, want 5", count)
+	}
 }
 
-func TestMethodNotAllowed(t *testing.T) {
+func TestUploadReport(t *testing.T) {
 	t.Parallel()
 
-	_, handler, observedLogs := newTestServer(t, nil)
+	testDB, release := acquire(t)
+	defer release()
+
+	_, handler, observedLogs := newTestServer(t, testDB)
+
+	body := strings.Join([]string{
+		"ok one",
+		"ab",
+		"\n\nok two",
+		"ok one",
+		"\xff\xfe bad",
+		strings.Repeat("x", 10001),
+	}, "\n%\n")
 
 	for _, tt := range []ttest{
 		{
-			name:       "method not allowed",
-			method:     "PUT",
-			path:       "/",
-			wantStatus: http.StatusMethodNotAllowed,
-			wantText:   "Method Not Allowed\n",
+			name:        "insert a fortune",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/",
+			body:        []byte("ok two"),
+			wantStatus:  http.StatusCreated,
+		},
+		{
+			name:        "report",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/?report=1",
+			body:        []byte(body),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count":  {"1"},
+				"X-Duplicate-Count": {"2"},
+			},
+			wantJSON: map[string]any{
+				"total":    float64(6),
+				"inserted": float64(1),
+				"rejected": float64(5),
+				"reasons": map[string]any{
+					"too_short":    float64(1),
+					"too_long":     float64(1),
+					"invalid_utf8": float64(1),
+					"duplicate":    float64(2),
+				},
+				"rejections": []any{
+					map[string]any{"block": float64(1), "line": float64(3), "reason": "too_short"},
+					map[string]any{"block": float64(2), "line": float64(7), "reason": "duplicate"},
+					map[string]any{"block": float64(3), "line": float64(9), "reason": "duplicate"},
+					map[string]any{"block": float64(4), "line": float64(11), "reason": "invalid_utf8"},
+					map[string]any{"block": float64(5), "line": float64(13), "reason": "too_long"},
+				},
+			},
+		},
+		{
+			name:        "report requested with Accept",
+			method:      "POST",
+			contentType: "text/plain",
+			headers:     map[string]string{"Accept": "application/json"},
+			path:        "/",
+			body:        []byte("ok one"),
+			wantStatus:  http.StatusOK,
+			wantJSON: map[string]any{
+				"total":    float64(1),
+				"inserted": float64(0),
+				"rejections": []any{
+					map[string]any{"block": float64(0), "line": float64(1), "reason": "duplicate"},
+				},
+			},
+		},
+		{
+			name:        "report without valid fortunes",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/?report",
+			body:        []byte("ab\n%\nc"),
+			wantStatus:  http.StatusBadRequest,
+			wantJSON: map[string]any{
+				"total":    float64(2),
+				"inserted": float64(0),
+				"rejected": float64(2),
+			},
+		},
+		{
+			name:        "no report",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/?report=0",
+			headers:     map[string]string{"Accept": "application/json"},
+			body:        []byte("ok three"),
+			wantStatus:  http.StatusCreated,
+			wantEmpty:   true,
+		},
+		{
+			name:        "invalid report flag",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/?report=maybe",
+			body:        []byte("ok four"),
+			wantStatus:  http.StatusBadRequest,
+			wantText:    "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`400 invalid report flag "maybe"`,
+				},
+			},
 		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
@@ -239,11 +675,909 @@ func TestMethodNotAllowed(t *testing.T) {
 	}
 }
 
-func TestHealthz(t *testing.T) {
+func TestGET(t *testing.T) {
 	t.Parallel()
 
-	_, handler, observedLogs := newTestServer(t, nil)
-
+	testDB, release := acquire(t)
+	defer release()
+
+	_, handler, observedLogs := newTestServer(t, testDB)
+
+	expectedFortune := `"bazbar
+ququux"
+		―  Romantic Hacker`
+
+	for _, tt := range []ttest{
+		{
+			name:       "no rows in the resultset",
+			method:     "GET",
+			path:       "/",
+			wantStatus: http.StatusNotFound,
+			wantText:   "Not Found\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`404 sql: no rows in result set`,
+				},
+			},
+		},
+		{
+			name:        "insert a fortune to get",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/",
+			body:        []byte(expectedFortune),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count": {"1"},
+			},
+		},
+		{
+			name:       "get a fortune",
+			method:     "GET",
+			path:       "/",
+			wantStatus: http.StatusOK,
+			wantText:   expectedFortune,
+			wantHeaders: map[string][]string{
+				"X-Fortune-Id":         {"1"},
+				"Link":                 {`</fortunes/1>; rel="canonical"`},
+				"X-Fortune-Collection": {"default"},
+			},
+		},
+		{
+			name:       "get a fortune as json",
+			method:     "GET",
+			path:       "/",
+			headers:    map[string]string{"Accept": "application/json"},
+			wantStatus: http.StatusOK,
+			wantJSON: map[string]any{
+				"id":         float64(1),
+				"text":       expectedFortune,
+				"length":     float64(len(expectedFortune)),
+				"collection": "default",
+			},
+			wantHeaders: map[string][]string{
+				"X-Fortune-Id": {"1"},
+			},
+		},
+		{
+			name:       "get a fortune with wildcard accept",
+			method:     "GET",
+			path:       "/",
+			headers:    map[string]string{"Accept": "*/*"},
+			wantStatus: http.StatusOK,
+			wantText:   expectedFortune,
+		},
+		{
+			name:       "prefer json by quality",
+			method:     "GET",
+			path:       "/",
+			headers:    map[string]string{"Accept": "text/plain;q=0.5, application/json"},
+			wantStatus: http.StatusOK,
+			wantJSON: map[string]any{
+				"id": float64(1),
+			},
+		},
+		{
+			name:       "unsupported accept",
+			method:     "GET",
+			path:       "/",
+			headers:    map[string]string{"Accept": "text/html"},
+			wantStatus: http.StatusNotAcceptable,
+			wantText:   "Not Acceptable\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`406 <nil>`,
+				},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, handler, observedLogs)
+		})
+	}
+}
+
+func TestGETByID(t *testing.T) {
+	t.Parallel()
+
+	testDB, release := acquire(t)
+	defer release()
+
+	_, handler, observedLogs := newTestServer(t, testDB)
+
+	for _, tt := range []ttest{
+		{
+			name:       "unknown id",
+			method:     "GET",
+			path:       "/fortunes/1",
+			wantStatus: http.StatusNotFound,
+			wantText:   "Not Found\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`404 sql: no rows in result set`,
+				},
+			},
+		},
+		{
+			name:       "malformed id",
+			method:     "GET",
+			path:       "/fortunes/abc",
+			wantStatus: http.StatusNotFound,
+			wantText:   "Not Found\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`404 strconv.ParseInt: parsing "abc": invalid syntax`,
+				},
+			},
+		},
+		{
+			name:       "non-positive id",
+			method:     "GET",
+			path:       "/fortunes/0",
+			wantStatus: http.StatusNotFound,
+			wantText:   "Not Found\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`404 <nil>`,
+				},
+			},
+		},
+		{
+			name:        "insert fortunes to get",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/",
+			body:        []byte("first\n%\nsecond"),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count": {"2"},
+			},
+		},
+		{
+			name:       "get a fortune by id",
+			method:     "GET",
+			path:       "/fortunes/2",
+			wantStatus: http.StatusOK,
+			wantText:   "second",
+			wantHeaders: map[string][]string{
+				"X-Fortune-Id": {"2"},
+				"Link":         {`</fortunes/2>; rel="canonical"`},
+			},
+		},
+		{
+			name:       "get a fortune by id as json",
+			method:     "GET",
+			path:       "/fortunes/2",
+			headers:    map[string]string{"Accept": "application/json"},
+			wantStatus: http.StatusOK,
+			wantJSON: map[string]any{
+				"id":     float64(2),
+				"text":   "second",
+				"length": float64(6),
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, handler, observedLogs)
+		})
+	}
+}
+
+func TestCollections(t *testing.T) {
+	t.Parallel()
+
+	testDB, release := acquire(t)
+	defer release()
+
+	_, handler, observedLogs := newTestServer(t, testDB)
+
+	for _, tt := range []ttest{
+		{
+			name:       "no collections",
+			method:     "GET",
+			path:       "/collections",
+			wantStatus: http.StatusOK,
+			wantEmpty:  true,
+		},
+		{
+			name:       "unknown collection",
+			method:     "GET",
+			path:       "/computers",
+			wantStatus: http.StatusNotFound,
+			wantText:   "Not Found\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`404 collection "computers" not found`,
+				},
+			},
+		},
+		{
+			name:        "insert into a collection",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/computers",
+			body:        []byte("There is no place like 127.0.0.1"),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count": {"1"},
+			},
+		},
+		{
+			name:        "insert into another collection",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/art",
+			body:        []byte("art one\n%\nart two"),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count": {"2"},
+			},
+		},
+		{
+			name:       "get from a collection",
+			method:     "GET",
+			path:       "/computers",
+			wantStatus: http.StatusOK,
+			wantText:   "There is no place like 127.0.0.1",
+			wantHeaders: map[string][]string{
+				"X-Fortune-Id":         {"1"},
+				"X-Fortune-Collection": {"computers"},
+			},
+		},
+		{
+			name:       "list collections",
+			method:     "GET",
+			path:       "/collections",
+			wantStatus: http.StatusOK,
+			wantText:   "art\t2\ncomputers\t1\n",
+		},
+		{
+			name:       "invalid collection name",
+			method:     "GET",
+			path:       "/Computers",
+			wantStatus: http.StatusNotFound,
+			wantText:   "Not Found\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`404 collection "Computers" not found`,
+				},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, handler, observedLogs)
+		})
+	}
+}
+
+func TestOffensive(t *testing.T) {
+	t.Parallel()
+
+	testDB, release := acquire(t)
+	defer release()
+
+	_, handler, observedLogs := newTestServer(t, testDB)
+
+	for _, tt := range []ttest{
+		{
+			name:        "invalid offensive flag",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/?offensive=maybe",
+			body:        []byte("Why did the chicken cross the road?"),
+			wantStatus:  http.StatusBadRequest,
+			wantText:    "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`400 invalid offensive flag "maybe"`,
+				},
+			},
+		},
+		{
+			name:        "insert an offensive fortune",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/?offensive=true",
+			body:        []byte("Why did the chicken cross the road?"),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count": {"1"},
+			},
+		},
+		{
+			name:        "insert an offensive fortune using the header",
+			method:      "POST",
+			contentType: "text/plain",
+			headers:     map[string]string{"X-Fortune-Offensive": "1"},
+			path:        "/",
+			body:        []byte("To get to the other side!"),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count": {"1"},
+			},
+		},
+		{
+			name:       "offensive fortunes are excluded by default",
+			method:     "GET",
+			path:       "/",
+			wantStatus: http.StatusNotFound,
+			wantText:   "Not Found\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`404 sql: no rows in result set`,
+				},
+			},
+		},
+		{
+			name:        "insert an inoffensive fortune",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/?offensive=false",
+			body:        []byte("Be nice."),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count": {"1"},
+			},
+		},
+		{
+			name:       "exclude offensive fortunes",
+			method:     "GET",
+			path:       "/?offensive=exclude",
+			wantStatus: http.StatusOK,
+			wantText:   "Be nice.",
+		},
+		{
+			name:       "get an offensive fortune by id",
+			method:     "GET",
+			path:       "/fortunes/1",
+			headers:    map[string]string{"Accept": "application/json"},
+			wantStatus: http.StatusOK,
+			wantJSON: map[string]any{
+				"text":      "Why did the chicken cross the road?",
+				"offensive": true,
+			},
+		},
+		{
+			name:       "invalid offensive mode",
+			method:     "GET",
+			path:       "/?offensive=sometimes",
+			wantStatus: http.StatusBadRequest,
+			wantText:   "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`400 invalid offensive mode "sometimes"`,
+				},
+			},
+		},
+		{
+			name:       "list collections counts offensive fortunes",
+			method:     "GET",
+			path:       "/collections",
+			wantStatus: http.StatusOK,
+			wantText:   "default\t3\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, handler, observedLogs)
+		})
+	}
+
+	// Offensive fortunes are stored rot13-encoded.
+	var stored string
+	if err := testDB.QueryRow(context.Background(), `SELECT value FROM fortune_cookies WHERE id = 2`).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Gb trg gb gur bgure fvqr!"; stored != want {
+		t.Errorf("stored value = %q, want %q", stored, want)
+	}
+
+	for _, tt := range []ttest{
+		{
+			name:       "only offensive fortunes",
+			method:     "GET",
+			path:       "/?offensive=only",
+			headers:    map[string]string{"Accept": "application/json"},
+			wantStatus: http.StatusOK,
+			wantJSON: map[string]any{
+				"offensive": true,
+			},
+		},
+		{
+			name:       "include offensive fortunes",
+			method:     "GET",
+			path:       "/default?offensive=include",
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string][]string{
+				"X-Fortune-Collection": {"default"},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, handler, observedLogs)
+		})
+	}
+}
+
+func TestLength(t *testing.T) {
+	t.Parallel()
+
+	testDB, release := acquire(t)
+	defer release()
+
+	_, handler, observedLogs := newTestServer(t, testDB)
+
+	long := strings.Repeat("All work and no play makes Jack a dull boy. ", 4)
+
+	for _, tt := range []ttest{
+		{
+			name:        "insert fortunes",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/",
+			body:        []byte("Be brief.\n%\n" + long),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count": {"2"},
+			},
+		},
+		{
+			name:       "short fortunes",
+			method:     "GET",
+			path:       "/?short",
+			wantStatus: http.StatusOK,
+			wantText:   "Be brief.",
+		},
+		{
+			name:       "long fortunes",
+			method:     "GET",
+			path:       "/?long=1",
+			headers:    map[string]string{"Accept": "application/json"},
+			wantStatus: http.StatusOK,
+			wantJSON: map[string]any{
+				"length": float64(len(strings.TrimSpace(long))),
+			},
+		},
+		{
+			name:       "short fortunes with a custom length",
+			method:     "GET",
+			path:       "/?short&n=5",
+			wantStatus: http.StatusNotFound,
+			wantText:   "Not Found\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`404 sql: no rows in result set`,
+				},
+			},
+		},
+		{
+			name:       "long fortunes with a custom length",
+			method:     "GET",
+			path:       "/?long&n=5",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "length alone does not filter",
+			method:     "GET",
+			path:       "/?n=5",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "short and long",
+			method:     "GET",
+			path:       "/?short&long",
+			wantStatus: http.StatusBadRequest,
+			wantText:   "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`400 short and long are mutually exclusive`,
+				},
+			},
+		},
+		{
+			name:       "invalid length",
+			method:     "GET",
+			path:       "/?short&n=0",
+			wantStatus: http.StatusBadRequest,
+			wantText:   "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`400 invalid length "0"`,
+				},
+			},
+		},
+		{
+			name:       "invalid short flag",
+			method:     "GET",
+			path:       "/?short=yes",
+			wantStatus: http.StatusBadRequest,
+			wantText:   "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`400 invalid short flag "yes"`,
+				},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, handler, observedLogs)
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	t.Parallel()
+
+	testDB, release := acquire(t)
+	defer release()
+
+	_, handler, observedLogs := newTestServer(t, testDB)
+
+	for _, tt := range []ttest{
+		{
+			name:        "insert fortunes",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/",
+			body:        []byte("Why did the chicken cross the road?\n%\nTo get to the other side!\n%\nThe Road goes ever on and on."),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count": {"3"},
+			},
+		},
+		{
+			name:        "insert an offensive fortune",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/?offensive=1",
+			body:        []byte("The road to hell is paved with good intentions."),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count": {"1"},
+			},
+		},
+		{
+			name:       "search",
+			method:     "GET",
+			path:       "/search?pattern=road",
+			wantStatus: http.StatusOK,
+			wantText:   "Why did the chicken cross the road?\n%\n",
+			wantHeaders: map[string][]string{
+				"X-Match-Count": {"1"},
+			},
+		},
+		{
+			name:       "case-insensitive search",
+			method:     "GET",
+			path:       "/search?pattern=road&i=1",
+			wantStatus: http.StatusOK,
+			wantText:   "Why did the chicken cross the road?\n%\nThe Road goes ever on and on.\n%\n",
+			wantHeaders: map[string][]string{
+				"X-Match-Count": {"2"},
+			},
+		},
+		{
+			name:       "search offensive fortunes",
+			method:     "GET",
+			path:       "/search?pattern=" + url.QueryEscape("^The road") + "&offensive=only",
+			wantStatus: http.StatusOK,
+			wantText:   "The road to hell is paved with good intentions.\n%\n",
+		},
+		{
+			name:       "search short fortunes",
+			method:     "GET",
+			path:       "/search?pattern=" + url.QueryEscape("(?i)road|side") + "&short&n=30",
+			wantStatus: http.StatusOK,
+			wantText:   "To get to the other side!\n%\nThe Road goes ever on and on.\n%\n",
+		},
+		{
+			name:       "no matches",
+			method:     "GET",
+			path:       "/search?pattern=turtle",
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string][]string{
+				"X-Match-Count": {"0"},
+			},
+		},
+		{
+			name:       "missing pattern",
+			method:     "GET",
+			path:       "/search",
+			wantStatus: http.StatusBadRequest,
+			wantText:   "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`400 missing pattern`,
+				},
+			},
+		},
+		{
+			name:       "invalid pattern",
+			method:     "GET",
+			path:       "/search?pattern=" + url.QueryEscape("(road"),
+			wantStatus: http.StatusBadRequest,
+			wantText:   "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					"400 invalid pattern: error parsing regexp: missing closing ): `(road`",
+				},
+			},
+		},
+		{
+			name:       "pattern too long",
+			method:     "GET",
+			path:       "/search?pattern=" + strings.Repeat("a", 257),
+			wantStatus: http.StatusBadRequest,
+			wantText:   "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`400 pattern too long (257 > 256 bytes)`,
+				},
+			},
+		},
+		{
+			name:       "pattern too complex",
+			method:     "GET",
+			path:       "/search?pattern=" + url.QueryEscape(`(\w{100}){10}`),
+			wantStatus: http.StatusBadRequest,
+			wantText:   "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`400 pattern too complex (1022 > 1000 instructions)`,
+				},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, handler, observedLogs)
+		})
+	}
+}
+
+func TestFullTextSearch(t *testing.T) {
+	t.Parallel()
+
+	testDB, release := acquire(t)
+	defer release()
+
+	_, handler, observedLogs := newTestServer(t, testDB)
+
+	for _, tt := range []ttest{
+		{
+			name:        "insert fortunes",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/",
+			body: []byte(strings.Join([]string{
+				"Why did the chicken cross the road?",
+				"Chicken soup for the soul, chicken soup for the chicken.",
+				"A fox ate the chicken.",
+				"Fortune favors the bold.",
+				"You will have a pleasant surprise.",
+				"Beware of programmers who carry screwdrivers.",
+				"Never trust a computer you can't throw out a window.",
+				"Time flies like an arrow; fruit flies like a banana.",
+			}, "\n%\n")),
+			wantStatus: http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count": {"8"},
+			},
+		},
+		{
+			name:        "insert an offensive fortune",
+			method:      "POST",
+			contentType: "text/plain",
+			path:        "/?offensive=1",
+			body:        []byte("The chicken said something rude."),
+			wantStatus:  http.StatusCreated,
+			wantHeaders: map[string][]string{
+				"X-Inserted-Count": {"1"},
+			},
+		},
+		{
+			name:       "no matches",
+			method:     "GET",
+			path:       "/fortunes?q=turtle",
+			wantStatus: http.StatusOK,
+			wantJSON: map[string]any{
+				"results": []any{},
+			},
+		},
+		{
+			name:       "missing query",
+			method:     "GET",
+			path:       "/fortunes",
+			wantStatus: http.StatusBadRequest,
+			wantText:   "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`400 missing query`,
+				},
+			},
+		},
+		{
+			name:       "invalid limit",
+			method:     "GET",
+			path:       "/fortunes?q=chicken&limit=1000",
+			wantStatus: http.StatusBadRequest,
+			wantText:   "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`400 invalid limit "1000"`,
+				},
+			},
+		},
+		{
+			name:       "invalid cursor",
+			method:     "GET",
+			path:       "/fortunes?q=chicken&cursor=bm9wZQ",
+			wantStatus: http.StatusBadRequest,
+			wantText:   "Bad Request\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`400 invalid cursor "bm9wZQ"`,
+				},
+			},
+		},
+		{
+			name:       "unacceptable content type",
+			method:     "GET",
+			path:       "/fortunes?q=chicken",
+			headers:    map[string]string{"Accept": "text/plain"},
+			wantStatus: http.StatusNotAcceptable,
+			wantText:   "Not Acceptable\n",
+			wantLogs: []wantedLog{
+				{
+					"info",
+					`406 <nil>`,
+				},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, handler, observedLogs)
+		})
+	}
+
+	// Page through the results two at a time.
+	var (
+		results []*searchResult
+		pages   int
+		cursor  string
+	)
+	for {
+		path := "/fortunes?q=chicken&limit=2"
+		if cursor != "" {
+			path += "&cursor=" + cursor
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d, body %q", path, w.Code, w.Body.String())
+		}
+		var page struct {
+			Results []*searchResult `json:"results"`
+			Next    string          `json:"next"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, page.Results...)
+		pages++
+		if cursor = page.Next; cursor == "" {
+			break
+		}
+	}
+
+	assert.Equal(t, 2, pages)
+	if assert.Len(t, results, 3) {
+		// The most relevant fortune mentions chicken the most.
+		assert.Equal(t, "Chicken soup for the soul, chicken soup for the chicken.", results[0].Text)
+		for i, res := range results {
+			assert.False(t, res.Offensive)
+			assert.Contains(t, strings.ToLower(res.Snippet), "chicken")
+			if i > 0 {
+				assert.GreaterOrEqual(t, results[i-1].Score, res.Score)
+			}
+		}
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	t.Parallel()
+
+	s, handler, observedLogs := newTestServer(t, nil)
+	// Paths are checked before the database is used.
+	s.ready.Store(true)
+
+	for _, tt := range []ttest{
+		{
+			name:       "invalid GET path",
+			method:     "GET",
+			path:       "/not/much",
+			wantStatus: http.StatusNotFound,
+			wantText:   "Not Found\n",
+		},
+		{
+			name:       "invalid POST path",
+			method:     "POST",
+			path:       "/not/much",
+			wantStatus: http.StatusNotFound,
+			wantText:   "Not Found\n",
+		},
+		{
+			name:       "invalid collection name",
+			method:     "POST",
+			path:       "/Not%20Much",
+			wantStatus: http.StatusNotFound,
+			wantText:   "Not Found\n",
+		},
+		{
+			name:       "reserved collection name",
+			method:     "POST",
+			path:       "/collections",
+			wantStatus: http.StatusNotFound,
+			wantText:   "Not Found\n",
+		},
+		{
+			name:       "reserved collection name of a GET route",
+			method:     "POST",
+			path:       "/search",
+			wantStatus: http.StatusNotFound,
+			wantText:   "Not Found\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, handler, observedLogs)
+		})
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	_, handler, observedLogs := newTestServer(t, nil)
+
+	for _, tt := range []ttest{
+		{
+			name:       "method not allowed",
+			method:     "PUT",
+			path:       "/",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantText:   "Method Not Allowed\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, handler, observedLogs)
+		})
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	t.Parallel()
+
+	_, handler, observedLogs := newTestServer(t, nil)
+
 	for _, tt := range []ttest{
 		{
 			name:       "healthz - ok",
@@ -258,3 +1592,74 @@ func TestHealthz(t *testing.T) {
 		})
 	}
 }
+
+func TestReadyz(t *testing.T) {
+	t.Parallel()
+
+	testDB, release := acquire(t)
+	defer release()
+
+	_, handler, observedLogs := newTestServer(t, nil)
+	ready, readyHandler, readyLogs := newTestServer(t, testDB)
+
+	tt := ttest{
+		name:       "not connected",
+		path:       "/readyz",
+		wantStatus: http.StatusServiceUnavailable,
+		wantJSON: map[string]any{
+			"status": "fail",
+			"checks": map[string]any{
+				"database":   map[string]any{"status": "fail", "error": "not connected"},
+				"migrations": map[string]any{"status": "fail", "error": "not connected"},
+				"shutdown":   map[string]any{"status": "ok"},
+			},
+		},
+		wantLogs: []wantedLog{
+			{"warn", "readiness check database failed: not connected"},
+			{"warn", "readiness check migrations failed: not connected"},
+		},
+	}
+	t.Run(tt.name, func(t *testing.T) {
+		tt.run(t, handler, observedLogs)
+	})
+
+	checkReadyz := func(t *testing.T, wantStatus int) map[string]any {
+		t.Helper()
+		w := httptest.NewRecorder()
+		readyHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if w.Code != wantStatus {
+			t.Fatalf("got status %d, want %d: %s", w.Code, wantStatus, w.Body)
+		}
+		var got map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		return got["checks"].(map[string]any)
+	}
+
+	t.Run("ready", func(t *testing.T) {
+		checks := checkReadyz(t, http.StatusOK)
+		db := checks["database"].(map[string]any)
+		assert.Equal(t, "ok", db["status"])
+		assert.Contains(t, db, "latency_ms")
+		migrations := checks["migrations"].(map[string]any)
+		assert.Equal(t, "ok", migrations["status"])
+		assert.Greater(t, migrations["version"], float64(0))
+		assert.Equal(t, migrations["expected_version"], migrations["version"])
+		assert.Empty(t, readyLogs.TakeAll())
+	})
+
+	t.Run("shutting down", func(t *testing.T) {
+		ready.StartShutdown()
+		checks := checkReadyz(t, http.StatusServiceUnavailable)
+		assert.Equal(t, map[string]any{"status": "fail", "error": "shutting down"}, checks["shutdown"])
+		assert.Equal(t, "ok", checks["database"].(map[string]any)["status"])
+
+		// A failing check is logged once, not on every probe.
+		checkReadyz(t, http.StatusServiceUnavailable)
+		logs := readyLogs.TakeAll()
+		if assert.Len(t, logs, 1) {
+			assert.Equal(t, "readiness check shutdown failed: shutting down", logs[0].Message)
+		}
+	})
+}
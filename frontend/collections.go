@@ -0,0 +1,120 @@
+package frontend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tetsuo/fortune/internal/cookieindex"
+	"github.com/tetsuo/fortune/internal/database"
+)
+
+// defaultCollection is the collection that fortunes posted to / are
+// inserted into.
+const defaultCollection = "default"
+
+// collectionNameRegexp matches valid collection names, such as the cookie
+// file names of the Unix fortune command.
+var collectionNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// reservedCollectionNames cannot be used as collection names since they
+// would be shadowed by other routes.
+var reservedCollectionNames = map[string]bool{
+	"collections": true,
+	"fortunes":    true,
+	"healthz":     true,
+	"readyz":      true,
+	"search":      true,
+}
+
+// validCollectionName reports whether name can be used as a collection name.
+func validCollectionName(name string) bool {
+	return collectionNameRegexp.MatchString(name) && !reservedCollectionNames[name]
+}
+
+// collection is a named group of fortunes, like a cookie file of the Unix
+// fortune command.
+type collection struct {
+	ID    int64  `json:"-"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// collectionNotFoundError is returned when a request refers to a collection
+// that does not exist.
+type collectionNotFoundError struct {
+	name string
+}
+
+func (e *collectionNotFoundError) Error() string {
+	return fmt.Sprintf("collection %q not found", e.name)
+}
+
+// ensureCollection returns the id of the collection with the given name,
+// creating the collection in db if it does not exist.
+func ensureCollection(ctx context.Context, db *database.DB, name string) (int64, error) {
+	if _, err := db.Exec(ctx, `INSERT INTO collections (name) VALUES (?)
+ON DUPLICATE KEY UPDATE name = name`, name); err != nil {
+		return 0, err
+	}
+	var id int64
+	if err := db.QueryRow(ctx, `SELECT id FROM collections WHERE name = ?`, name).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// serveCollections handles HTTP GET requests to list the collections along
+// with the number of fortunes, offensive or not, in each. The list is
+// written as tab-separated plain text or JSON, depending on the Accept
+// header of the request.
+func (s *Server) serveCollections(w http.ResponseWriter, r *http.Request) error {
+	contentType := negotiateContentType(r, fortuneContentTypes...)
+	if contentType == "" {
+		return &serverError{
+			status:       http.StatusNotAcceptable,
+			responseText: http.StatusText(http.StatusNotAcceptable),
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	li, err := s.cookieIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	collections := make([]collection, len(li.collections))
+	for i, c := range li.collections {
+		c.Count = li.Count(cookieindex.Filter{Collection: c.ID, Offensive: cookieindex.IncludeOffensive})
+		collections[i] = c
+	}
+
+	var body []byte
+	switch contentType {
+	case "application/json":
+		if body, err = json.Marshal(collections); err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+	default:
+		var b strings.Builder
+		for _, c := range collections {
+			fmt.Fprintf(&b, "%s\t%d\n", c.Name, c.Count)
+		}
+		body = []byte(b.String())
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Add("Vary", "Accept")
+
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(body)
+
+	return err
+}
@@ -94,6 +94,50 @@ type Config struct {
 	// Default: 1 minute.
 	ViewReportingPeriod time.Duration `env:"VIEW_REPORTING_PERIOD" envDefault:"1m" json:"viewReportingPeriod"`
 
+	// Maximum age of the in-memory index of fortune cookie ids used for random
+	// selection. The index is also reloaded after every insert.
+	// Default: 1 minute.
+	IndexRefreshInterval time.Duration `env:"INDEX_REFRESH_INTERVAL" envDefault:"1m" json:"indexRefreshInterval"`
+
+	// Maximum size of the body of a POST request, in bytes. Bodies are
+	// parsed and inserted as they arrive, so this doesn't affect memory use.
+	// Default: 64 MB (67108864 bytes).
+	MaxUploadSize int64 `env:"MAX_UPLOAD_SIZE" envDefault:"67108864" json:"maxUploadSize"`
+
+	// Longest fortune length, in bytes, considered short by the short and
+	// long query parameters when the request doesn't set one with n.
+	// Default: 160, like "fortune -n".
+	ShortFortuneLength int `env:"SHORT_FORTUNE_LENGTH" envDefault:"160" json:"shortFortuneLength"`
+
+	// Longest a database ping may take for /readyz to report the server as
+	// ready.
+	// Default: 1 second.
+	ReadyMaxPingLatency time.Duration `env:"READY_MAX_PING_LATENCY" envDefault:"1s" json:"readyMaxPingLatency"`
+
+	// Time to keep serving requests after receiving a termination signal,
+	// while /readyz reports that the server is shutting down, so that load
+	// balancers stop routing traffic to it first.
+	// Default: 0, shutting down immediately.
+	ShutdownDelay time.Duration `env:"SHUTDOWN_DELAY" envDefault:"0s" json:"shutdownDelay"`
+
+	// If true, applies the migrations embedded in the binary to the database
+	// at startup, before serving requests. Replicas starting together take
+	// turns, holding a MySQL advisory lock while migrating.
+	AutoMigrate bool `env:"AUTO_MIGRATE" json:"autoMigrate"`
+
+	// Longest to wait for another replica to finish migrating when
+	// AutoMigrate is set.
+	// Default: 5 minutes.
+	MigrateLockTimeout time.Duration `env:"MIGRATE_LOCK_TIMEOUT" envDefault:"5m" json:"migrateLockTimeout"`
+
+	// What to do at startup if the schema version of the database doesn't
+	// match the last migration embedded in the binary, or the last migration
+	// failed: "fail" to exit, "readonly" to reject uploads, or "warn" to only
+	// log it. Unless "fail", a schema that is behind or ahead doesn't fail
+	// the readiness check either.
+	// Default: fail.
+	SchemaMismatch string `env:"SCHEMA_MISMATCH" envDefault:"fail" json:"schemaMismatch"`
+
 	// Kubernetes service port (if running in a Kubernetes environment).
 	// This value is usually set by Kubernetes.
 	KubernetesServicePort int `env:"KUBERNETES_SERVICE_PORT" envDefault:"0" json:"-"`
@@ -102,6 +146,13 @@ type Config struct {
 	DB database.DBConfig
 }
 
+// Values of Config.SchemaMismatch.
+const (
+	SchemaMismatchFail     = "fail"
+	SchemaMismatchReadOnly = "readonly"
+	SchemaMismatchWarn     = "warn"
+)
+
 func (c Config) IsRunningOnGCE() bool {
 	return c.KubernetesServicePort != 0 && !c.IsRunningOnKind()
 }
@@ -0,0 +1,104 @@
+package frontend
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tetsuo/fortune/internal/cookiefile"
+	"github.com/tetsuo/fortune/internal/cookieindex"
+	"github.com/tetsuo/fortune/internal/database"
+)
+
+// SeedResult counts the fortunes of a cookie file loaded by Seed.
+type SeedResult struct {
+	Total      int   // number of fortunes in the file
+	Inserted   int64 // number of fortunes inserted
+	Duplicates int64 // number of fortunes already stored, or repeated
+	Rejected   int   // number of fortunes too short, too long or not UTF-8
+}
+
+// Seed inserts the fortunes of the cookie file read from r into the named
+// collection, creating it if necessary, like a POST request to the
+// collection does: fortunes are parsed the same way, and the invalid ones
+// and duplicates are skipped. The file is inserted in a single transaction.
+// If index is not nil, it is the strfile index of the file, which the file
+// must match; the entries of a rotated file are stored as they are.
+func Seed(ctx context.Context, db *database.DB, collection string, offensive bool, index *cookiefile.Index, r io.Reader) (SeedResult, error) {
+	if !validCollectionName(collection) {
+		return SeedResult{}, fmt.Errorf("invalid collection name %q", collection)
+	}
+	u := &upload{collection: collection, offensive: offensive, index: index}
+	err := db.Transact(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted}, func(tx *database.DB) error {
+		return u.run(ctx, tx, r)
+	})
+	return SeedResult{
+		Total:      u.total,
+		Inserted:   u.inserted,
+		Duplicates: u.duplicates,
+		Rejected:   u.total - u.valid,
+	}, err
+}
+
+// DumpFilter selects the fortunes returned by Dump.
+type DumpFilter struct {
+	Collection string                    // name of the collection, if not empty
+	Offensive  cookieindex.OffensiveMode // whether to select offensive fortunes
+	MinID      int64                     // smallest id, if positive
+	MaxID      int64                     // largest id, if positive
+}
+
+// DumpedFortune is a fortune returned by Dump.
+type DumpedFortune struct {
+	ID         int64
+	Collection string
+	Offensive  bool
+	Text       string // decoded, if offensive
+}
+
+// Dump calls f for each fortune selected by filter, in id order. It stops
+// at the first error returned by f.
+func Dump(ctx context.Context, db *database.DB, filter DumpFilter, f func(DumpedFortune) error) error {
+	var (
+		conds []string
+		args  []any
+	)
+	if filter.Collection != "" {
+		conds = append(conds, "c.name = ?")
+		args = append(args, filter.Collection)
+	}
+	switch filter.Offensive {
+	case cookieindex.ExcludeOffensive:
+		conds = append(conds, "NOT f.offensive")
+	case cookieindex.OnlyOffensive:
+		conds = append(conds, "f.offensive")
+	}
+	if filter.MinID > 0 {
+		conds = append(conds, "f.id >= ?")
+		args = append(args, filter.MinID)
+	}
+	if filter.MaxID > 0 {
+		conds = append(conds, "f.id <= ?")
+		args = append(args, filter.MaxID)
+	}
+	query := `SELECT f.id, c.name, f.offensive, f.value
+FROM fortune_cookies f
+JOIN collections c ON c.id = f.collection_id`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+	query += ` ORDER BY f.id`
+
+	return db.RunQuery(ctx, query, func(rows *sql.Rows) error {
+		var df DumpedFortune
+		if err := rows.Scan(&df.ID, &df.Collection, &df.Offensive, &df.Text); err != nil {
+			return err
+		}
+		if df.Offensive {
+			df.Text = cookiefile.Rot13(df.Text)
+		}
+		return f(df)
+	}, args...)
+}
@@ -0,0 +1,103 @@
+package frontend
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tetsuo/fortune/internal/cookiefile"
+	"github.com/tetsuo/fortune/internal/cookieindex"
+	"github.com/tetsuo/fortune/internal/database"
+)
+
+func TestSeedAndDump(t *testing.T) {
+	t.Parallel()
+
+	testDB, release := acquire(t)
+	defer release()
+
+	ctx := context.Background()
+
+	res, err := Seed(ctx, testDB, "art", false, nil, strings.NewReader("First art\n%\nab\n%\nSecond art\n%\nFirst art\n%\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, SeedResult{Total: 4, Inserted: 2, Duplicates: 1, Rejected: 1}, res)
+
+	res, err = Seed(ctx, testDB, "computers", true, nil, strings.NewReader("Offensive\nfortune\n%\nOffensive\nfortune\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, SeedResult{Total: 2, Inserted: 1, Duplicates: 1}, res)
+
+	if _, err := Seed(ctx, testDB, "fortunes", false, nil, strings.NewReader("Reserved")); err == nil {
+		t.Error("Seed to a reserved collection: got nil error")
+	}
+
+	dumpAll := func(filter DumpFilter) []DumpedFortune {
+		t.Helper()
+		var got []DumpedFortune
+		if err := Dump(ctx, testDB, filter, func(f DumpedFortune) error {
+			f.ID = 0 // not preserved by seeding
+			got = append(got, f)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	all := []DumpedFortune{
+		{Collection: "art", Text: "First art"},
+		{Collection: "art", Text: "Second art"},
+		{Collection: "computers", Offensive: true, Text: "Offensive\nfortune"},
+	}
+	assert.Equal(t, all, dumpAll(DumpFilter{Offensive: cookieindex.IncludeOffensive}))
+	assert.Equal(t, all[:2], dumpAll(DumpFilter{Offensive: cookieindex.ExcludeOffensive}))
+	assert.Equal(t, all[2:], dumpAll(DumpFilter{Offensive: cookieindex.OnlyOffensive}))
+	assert.Equal(t, all[:2], dumpAll(DumpFilter{Collection: "art", Offensive: cookieindex.IncludeOffensive}))
+
+	var ids []int64
+	if err := Dump(ctx, testDB, DumpFilter{Offensive: cookieindex.IncludeOffensive}, func(f DumpedFortune) error {
+		ids = append(ids, f.ID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, all[1:2], dumpAll(DumpFilter{Offensive: cookieindex.IncludeOffensive, MinID: ids[1], MaxID: ids[1]}))
+
+	// Seeding a dump, with a file per collection and offensiveness,
+	// reproduces the same corpus.
+	type file struct {
+		collection string
+		offensive  bool
+	}
+	var (
+		order   []file
+		written = map[file]*strings.Builder{}
+	)
+	for _, f := range all {
+		k := file{f.Collection, f.Offensive}
+		if written[k] == nil {
+			order = append(order, k)
+			written[k] = new(strings.Builder)
+		}
+		w := cookiefile.NewWriter(written[k])
+		if err := w.Write(f.Text); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.ResetDB(ctx, testDB); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range order {
+		if _, err := Seed(ctx, testDB, k.collection, k.offensive, nil, strings.NewReader(written[k].String())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(t, all, dumpAll(DumpFilter{Offensive: cookieindex.IncludeOffensive}))
+}
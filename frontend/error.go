@@ -31,6 +31,25 @@ func (s *serverError) Unwrap() error {
 	return s.err
 }
 
+// errNotReady is returned for requests received before the database is
+// connected.
+var errNotReady = &serverError{
+	status:       http.StatusServiceUnavailable,
+	responseText: http.StatusText(http.StatusServiceUnavailable),
+	err:          errors.New("database is not ready"),
+}
+
+// errReadOnly is returned for uploads to a read-only server.
+var errReadOnly = &serverError{
+	status:       http.StatusServiceUnavailable,
+	responseText: http.StatusText(http.StatusServiceUnavailable),
+	err:          errors.New("server is read-only"),
+}
+
+// notReadyRetryAfter is the Retry-After header of responses to requests
+// received before the database is connected, in seconds.
+const notReadyRetryAfter = "5"
+
 // serveError handles errors returned from request handlers by determining the appropriate
 // HTTP response status, logging the error, and reporting it to an error tracking service
 // if applicable.
@@ -56,6 +75,11 @@ func (s *Server) serveError(w http.ResponseWriter, r *http.Request, err error) {
 // proper handling and logging.
 func (s *Server) errorHandler(f func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.Ready() {
+			w.Header().Set("Retry-After", notReadyRetryAfter)
+			s.serveError(w, r, errNotReady)
+			return
+		}
 		if err := f(w, r); err != nil {
 			s.serveError(w, r, err)
 		}
@@ -0,0 +1,270 @@
+package frontend
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxQueryLength is the maximum length of a full-text search query, in
+	// bytes.
+	maxQueryLength = 256
+
+	// defaultSearchLimit and maxSearchLimit are the default and maximum
+	// number of results per page of full-text search results.
+	defaultSearchLimit = 10
+	maxSearchLimit     = 100
+
+	// snippetLength is the approximate length of a search result snippet,
+	// in bytes, and snippetContext the length of the text preceding the
+	// first matching term in it.
+	snippetLength  = 160
+	snippetContext = 40
+)
+
+// searchResult is a fortune matching a full-text search query.
+type searchResult struct {
+	fortune
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+// searchPage is a page of full-text search results. Next is the cursor of
+// the following page, if any.
+type searchPage struct {
+	Results []*searchResult `json:"results"`
+	Next    string          `json:"next,omitempty"`
+}
+
+// serveFullTextSearch handles HTTP GET requests to search the fortunes
+// using the FULLTEXT index of fortune_cookies. It returns the fortunes
+// matching the q query parameter in natural language mode as JSON, most
+// relevant first, along with a snippet of each around the first matching
+// term. Results are paginated with the limit query parameter and the
+// opaque cursor of the previous page. Offensive fortunes are never
+// returned, since their stored text is rot13-encoded. Returns a 400 error
+// if the query, limit or cursor is invalid.
+func (s *Server) serveFullTextSearch(w http.ResponseWriter, r *http.Request) error {
+	if negotiateContentType(r, "application/json") == "" {
+		return &serverError{
+			status:       http.StatusNotAcceptable,
+			responseText: http.StatusText(http.StatusNotAcceptable),
+		}
+	}
+
+	q := r.URL.Query()
+
+	query := strings.TrimSpace(q.Get("q"))
+	var err error
+	switch {
+	case query == "":
+		err = errors.New("missing query")
+	case len(query) > maxQueryLength:
+		err = fmt.Errorf("query too long (%d > %d bytes)", len(query), maxQueryLength)
+	}
+	if err != nil {
+		return &serverError{
+			status:       http.StatusBadRequest,
+			responseText: http.StatusText(http.StatusBadRequest),
+			err:          err,
+		}
+	}
+
+	limit := defaultSearchLimit
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			return &serverError{
+				status:       http.StatusBadRequest,
+				responseText: http.StatusText(http.StatusBadRequest),
+				err:          fmt.Errorf("invalid limit %q", v),
+			}
+		}
+	}
+
+	var after *searchCursor
+	if v := q.Get("cursor"); v != "" {
+		if after, err = decodeSearchCursor(v); err != nil {
+			return &serverError{
+				status:       http.StatusBadRequest,
+				responseText: http.StatusText(http.StatusBadRequest),
+				err:          err,
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	page, err := s.fullTextSearch(ctx, query, after, limit)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(page)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept")
+
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(body)
+
+	return err
+}
+
+// fullTextSearch returns a page of at most limit fortunes matching query,
+// following the given cursor, or from the start if it is nil.
+func (s *Server) fullTextSearch(ctx context.Context, query string, after *searchCursor, limit int) (*searchPage, error) {
+	// Scores are cast to DECIMAL so that they compare exactly with the
+	// score of a cursor.
+	sqlQuery := `SELECT id, value, created_at, collection, score
+FROM (
+	SELECT f.id, f.value, f.created_at, c.name AS collection,
+		CAST(MATCH (f.value) AGAINST (? IN NATURAL LANGUAGE MODE) AS DECIMAL(20, 10)) AS score
+	FROM fortune_cookies f
+	JOIN collections c ON c.id = f.collection_id
+	WHERE MATCH (f.value) AGAINST (? IN NATURAL LANGUAGE MODE) AND NOT f.offensive
+) AS results`
+	args := []any{query, query}
+	if after != nil {
+		sqlQuery += `
+WHERE score < CAST(? AS DECIMAL(20, 10)) OR (score = CAST(? AS DECIMAL(20, 10)) AND id > ?)`
+		args = append(args, after.score, after.score, after.id)
+	}
+	sqlQuery += `
+ORDER BY score DESC, id
+LIMIT ?`
+	// Fetch one more result to tell whether there is a next page.
+	args = append(args, limit+1)
+
+	terms := queryTermsRegexp(query)
+
+	page := &searchPage{Results: []*searchResult{}}
+	var last searchCursor
+	err := s.db.RunQuery(ctx, sqlQuery, func(rows *sql.Rows) error {
+		var (
+			res   searchResult
+			score string
+		)
+		if err := rows.Scan(&res.ID, &res.Text, &res.CreatedAt, &res.Collection, &score); err != nil {
+			return err
+		}
+		if len(page.Results) == limit {
+			page.Next = last.encode()
+			return nil
+		}
+		var err error
+		if res.Score, err = strconv.ParseFloat(score, 64); err != nil {
+			return err
+		}
+		res.Length = len(res.Text)
+		res.Snippet = snippet(res.Text, terms)
+		page.Results = append(page.Results, &res)
+		last = searchCursor{score: score, id: res.ID}
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// searchCursor identifies the last result of a page of full-text search
+// results by its score and id.
+type searchCursor struct {
+	score string // decimal
+	id    int64
+}
+
+var cursorScoreRegexp = regexp.MustCompile(`^[0-9]{1,10}(\.[0-9]{1,10})?$`)
+
+// encode returns the opaque representation of c used in responses.
+func (c searchCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.score + ":" + strconv.FormatInt(c.id, 10)))
+}
+
+// decodeSearchCursor parses a cursor returned by searchCursor.encode.
+func decodeSearchCursor(s string) (*searchCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor %q", s)
+	}
+	score, id, ok := strings.Cut(string(b), ":")
+	if !ok || !cursorScoreRegexp.MatchString(score) {
+		return nil, fmt.Errorf("invalid cursor %q", s)
+	}
+	c := &searchCursor{score: score}
+	if c.id, err = strconv.ParseInt(id, 10, 64); err != nil || c.id < 1 {
+		return nil, fmt.Errorf("invalid cursor %q", s)
+	}
+	return c, nil
+}
+
+// queryTermsRegexp returns a regular expression matching the words of a
+// full-text search query, or nil if it has none.
+func queryTermsRegexp(query string) *regexp.Regexp {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return nil
+	}
+	for i, w := range words {
+		words[i] = regexp.QuoteMeta(w)
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(words, "|") + `)`)
+}
+
+// snippet returns an excerpt of text of about snippetLength bytes, starting
+// shortly before the first match of terms, with whitespace collapsed and
+// ellipses marking omitted text.
+func snippet(text string, terms *regexp.Regexp) string {
+	start, end := 0, len(text)
+	if len(text) > snippetLength {
+		if terms != nil {
+			if loc := terms.FindStringIndex(text); loc != nil && loc[0] > snippetContext {
+				start = loc[0] - snippetContext
+				// Skip the partial word at the start.
+				if i := strings.IndexAny(text[start:loc[0]], " \t\n"); i >= 0 {
+					start += i + 1
+				}
+			}
+		}
+		end = min(start+snippetLength, len(text))
+		// Drop the partial word at the end.
+		if end < len(text) {
+			if i := strings.LastIndexAny(text[start:end], " \t\n"); i > 0 {
+				end = start + i
+			}
+		}
+		for start < end && !utf8.RuneStart(text[start]) {
+			start++
+		}
+		for end < len(text) && end > start && !utf8.RuneStart(text[end]) {
+			end--
+		}
+	}
+	s := strings.Join(strings.Fields(text[start:end]), " ")
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(text) {
+		s += "…"
+	}
+	return s
+}
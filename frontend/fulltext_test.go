@@ -0,0 +1,68 @@
+package frontend
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("lorem ipsum ", 20) + "the chicken crossed\nthe road " + strings.Repeat("dolor sit amet ", 20)
+
+	for _, tt := range []struct {
+		name  string
+		text  string
+		query string
+		want  string
+	}{
+		{
+			name:  "short text",
+			text:  "Why did the chicken\n  cross the road?",
+			query: "chicken",
+			want:  "Why did the chicken cross the road?",
+		},
+		{
+			name:  "match in the middle",
+			text:  long,
+			query: "chicken",
+			want:  "…ipsum lorem ipsum lorem ipsum the chicken crossed the road dolor sit amet dolor sit amet dolor sit amet dolor sit amet dolor sit amet dolor sit amet dolor sit…",
+		},
+		{
+			name:  "no match",
+			text:  long,
+			query: "turtle",
+			want:  strings.TrimSpace(strings.Repeat("lorem ipsum ", 13)) + "…",
+		},
+		{
+			name:  "multibyte text",
+			text:  strings.Repeat("ğ", 100),
+			query: "ğ",
+			want:  strings.Repeat("ğ", 80) + "…",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, snippet(tt.text, queryTermsRegexp(tt.query)))
+		})
+	}
+}
+
+func TestSearchCursor(t *testing.T) {
+	c := searchCursor{score: "0.2276446968", id: 42}
+	got, err := decodeSearchCursor(c.encode())
+	if assert.NoError(t, err) {
+		assert.Equal(t, c, *got)
+	}
+
+	for _, s := range []string{
+		"",
+		"!",
+		searchCursor{score: "1e10", id: 1}.encode(),
+		searchCursor{score: "1", id: 0}.encode(),
+		searchCursor{score: "1 OR 1=1", id: 1}.encode(),
+	} {
+		if _, err := decodeSearchCursor(s); err == nil {
+			t.Errorf("decodeSearchCursor(%q) succeeded, want error", s)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package frontend
+
+import (
+	"context"
+	"time"
+
+	"github.com/tetsuo/fortune/internal/cookieindex"
+	"github.com/tetsuo/fortune/internal/database"
+)
+
+// defaultIndexRefreshInterval is the maximum age of the cookie index,
+// unless configured otherwise.
+const defaultIndexRefreshInterval = time.Minute
+
+// loadedIndex is a cookie index along with the collections it refers to and
+// the time it was loaded.
+type loadedIndex struct {
+	*cookieindex.Index
+	collections []collection // sorted by name
+	loadedAt    time.Time
+}
+
+// collection returns the collection with the given name, if it exists.
+func (li *loadedIndex) collection(name string) (collection, bool) {
+	for _, c := range li.collections {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return collection{}, false
+}
+
+// cookieIndex returns the index of fortune cookies used for random
+// selection. The index is reloaded from the database when it has not been
+// loaded yet, when it was invalidated by an insert, or when it is older than
+// the configured refresh interval.
+func (s *Server) cookieIndex(ctx context.Context) (*loadedIndex, error) {
+	if li := s.index.Load(); s.isFresh(li) {
+		return li, nil
+	}
+
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	// Another request may have reloaded the index while we were waiting.
+	if li := s.index.Load(); s.isFresh(li) {
+		return li, nil
+	}
+
+	// Clear the flag before loading, so that inserts committed while the
+	// index is being loaded invalidate it again.
+	if s.indexStale.Swap(false) {
+		// Read replicas may not have applied the inserts yet.
+		ctx = database.WithPrimary(ctx)
+	}
+
+	li, err := s.loadCookieIndex(ctx)
+	if err != nil {
+		s.indexStale.Store(true)
+		return nil, err
+	}
+	s.index.Store(li)
+
+	return li, nil
+}
+
+// isFresh reports whether li can be used without reloading it.
+func (s *Server) isFresh(li *loadedIndex) bool {
+	return li != nil && !s.indexStale.Load() && time.Since(li.loadedAt) < s.indexRefreshInterval
+}
+
+// invalidateCookieIndex makes the next call to cookieIndex reload the index.
+func (s *Server) invalidateCookieIndex() {
+	s.indexStale.Store(true)
+}
+
+// loadCookieIndex reads the collections and the ids of all fortune cookies
+// from the database.
+func (s *Server) loadCookieIndex(ctx context.Context) (*loadedIndex, error) {
+	li := &loadedIndex{loadedAt: time.Now()}
+
+	// Collections are read first, so that every cookie loaded below belongs
+	// to a known collection.
+	var err error
+	li.collections, err = database.CollectStructs[collection](ctx, s.db, `SELECT id, name FROM collections ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := database.CollectStructs[cookieindex.Entry](ctx, s.db,
+		`SELECT id, collection_id AS collection, offensive, length FROM fortune_cookies`)
+	if err != nil {
+		return nil, err
+	}
+	li.Index = cookieindex.New(entries)
+
+	return li, nil
+}
@@ -0,0 +1,83 @@
+package frontend
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/tetsuo/fortune/internal/cookieindex"
+)
+
+// defaultShortLength is the longest fortune length, in bytes, considered
+// short unless configured otherwise. It is the default of "fortune -n".
+const defaultShortLength = 160
+
+// fortuneFilter returns the cookie index filter selected by the offensive,
+// short, long and n query parameters of a GET request.
+func (s *Server) fortuneFilter(r *http.Request) (cookieindex.Filter, error) {
+	mode, err := offensiveMode(r)
+	if err != nil {
+		return cookieindex.Filter{}, err
+	}
+	minLen, maxLen, err := lengthBounds(r.URL.Query(), s.shortLength)
+	if err != nil {
+		return cookieindex.Filter{}, err
+	}
+	return cookieindex.Filter{Offensive: mode, MinLength: minLen, MaxLength: maxLen}, nil
+}
+
+// lengthBounds returns the bounds on fortune length selected by the short
+// and long query parameters, like "fortune -s" and "fortune -l". Fortunes
+// at most n bytes long are short, and the others are long, where n is given
+// by the n query parameter or else by shortLength. A maxLen of zero means
+// there is no upper bound.
+func lengthBounds(q url.Values, shortLength int) (minLen, maxLen int, err error) {
+	short, err := flagParam(q, "short")
+	if err != nil {
+		return 0, 0, err
+	}
+	long, err := flagParam(q, "long")
+	if err != nil {
+		return 0, 0, err
+	}
+	if short && long {
+		return 0, 0, errors.New("short and long are mutually exclusive")
+	}
+
+	n := shortLength
+	if q.Has("n") {
+		v := q.Get("n")
+		n, err = strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid length %q", v)
+		}
+	}
+
+	switch {
+	case short:
+		return 0, n, nil
+	case long:
+		return n + 1, 0, nil
+	default:
+		return 0, 0, nil
+	}
+}
+
+// flagParam reports whether the named query parameter is set. A parameter
+// without a value, as in "?short", counts as set.
+func flagParam(q url.Values, name string) (bool, error) {
+	if !q.Has(name) {
+		return false, nil
+	}
+	v := q.Get(name)
+	if v == "" {
+		return true, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s flag %q", name, v)
+	}
+	return b, nil
+}
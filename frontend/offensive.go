@@ -0,0 +1,45 @@
+package frontend
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/tetsuo/fortune/internal/cookieindex"
+)
+
+// offensiveHeader marks the fortunes of a POST request as offensive, as an
+// alternative to the offensive query parameter.
+const offensiveHeader = "X-Fortune-Offensive"
+
+// uploadIsOffensive reports whether the fortunes of a POST request are
+// marked as offensive by the offensive query parameter or header.
+func uploadIsOffensive(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("offensive")
+	if v == "" {
+		v = r.Header.Get(offensiveHeader)
+	}
+	if v == "" {
+		return false, nil
+	}
+	offensive, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid offensive flag %q", v)
+	}
+	return offensive, nil
+}
+
+// offensiveMode returns the offensive mode selected by the offensive query
+// parameter of a GET request. Offensive fortunes are excluded by default.
+func offensiveMode(r *http.Request) (cookieindex.OffensiveMode, error) {
+	switch v := r.URL.Query().Get("offensive"); v {
+	case "", "exclude":
+		return cookieindex.ExcludeOffensive, nil
+	case "include":
+		return cookieindex.IncludeOffensive, nil
+	case "only":
+		return cookieindex.OnlyOffensive, nil
+	default:
+		return 0, fmt.Errorf("invalid offensive mode %q", v)
+	}
+}
@@ -0,0 +1,174 @@
+package frontend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/tetsuo/fortune/internal/database"
+)
+
+// defaultMaxPingLatency is the longest a database ping may take for the
+// server to be ready, unless configured otherwise.
+const defaultMaxPingLatency = time.Second
+
+// Statuses of readiness checks.
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+// checkResult is the result of a readiness check.
+type checkResult struct {
+	Status    string   `json:"status"`
+	Error     string   `json:"error,omitempty"`
+	LatencyMS *float64 `json:"latency_ms,omitempty"`
+	Version   *uint    `json:"version,omitempty"`
+	Expected  *uint    `json:"expected_version,omitempty"`
+	Dirty     bool     `json:"dirty,omitempty"`
+}
+
+// readiness is the response body of /readyz.
+type readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// errNoDB is the error of the database checks before the database is
+// connected.
+var errNoDB = errors.New("not connected")
+
+// StartShutdown makes the server report that it isn't ready, so that no more
+// traffic is routed to it while it drains the requests in progress.
+func (s *Server) StartShutdown() {
+	s.shuttingDown.Store(true)
+}
+
+// serveReadyz reports whether the server can serve requests: the database
+// must be connected, respond to a ping in time and be migrated, and the
+// server must not be shutting down. The response breaks the result down by
+// check, with status 200 OK if all pass and 503 Service Unavailable
+// otherwise.
+func (s *Server) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	rd := readiness{
+		Status: checkOK,
+		Checks: map[string]checkResult{
+			"database":   s.checkDatabase(r.Context()),
+			"migrations": s.checkMigrations(r.Context()),
+			"shutdown":   s.checkShutdown(),
+		},
+	}
+	status := http.StatusOK
+	for _, c := range rd.Checks {
+		if c.Status != checkOK {
+			rd.Status = checkFail
+			status = http.StatusServiceUnavailable
+		}
+	}
+	s.logChecks(rd.Checks)
+
+	body, err := json.Marshal(rd)
+	if err != nil {
+		s.serveError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// logChecks logs the checks whose status changed since the last probe, so
+// that a failing check is logged once rather than on every probe.
+func (s *Server) logChecks(checks map[string]checkResult) {
+	s.checksMu.Lock()
+	defer s.checksMu.Unlock()
+
+	for _, name := range slices.Sorted(maps.Keys(checks)) {
+		c := checks[name]
+		last, ok := s.checkStatus[name]
+		if !ok {
+			last = checkOK
+		}
+		s.checkStatus[name] = c.Status
+		switch {
+		case c.Status == last:
+		case c.Status == checkOK:
+			s.log.Infof("readiness check %s passed", name)
+		default:
+			s.log.Warnf("readiness check %s failed: %s", name, c.Error)
+		}
+	}
+}
+
+// checkDatabase pings the primary database, failing if it takes longer than
+// the configured maximum latency.
+func (s *Server) checkDatabase(ctx context.Context) checkResult {
+	if !s.Ready() {
+		return checkResult{Status: checkFail, Error: errNoDB.Error()}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.maxPingLatency)
+	defer cancel()
+
+	start := time.Now()
+	err := s.db.Ping(ctx)
+	latency := float64(time.Since(start).Microseconds()) / 1000
+
+	c := checkResult{Status: checkOK, LatencyMS: &latency}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		c.Status = checkFail
+		c.Error = fmt.Sprintf("ping took longer than %s", s.maxPingLatency)
+	case err != nil:
+		c.Status = checkFail
+		c.Error = err.Error()
+	}
+	return c
+}
+
+// checkMigrations fails unless the database schema is at the last migration
+// embedded in the binary, applied successfully. A schema that is behind or
+// ahead passes if the server was configured to serve it anyway.
+func (s *Server) checkMigrations(ctx context.Context) checkResult {
+	if !s.Ready() {
+		return checkResult{Status: checkFail, Error: errNoDB.Error()}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.maxPingLatency)
+	defer cancel()
+
+	version, dirty, err := s.db.SchemaVersion(ctx)
+	if err != nil {
+		return checkResult{Status: checkFail, Error: err.Error()}
+	}
+
+	state := database.SchemaState{Version: version, Dirty: dirty, Latest: s.latestMigration}
+	c := checkResult{Status: checkOK, Version: &version, Expected: &state.Latest, Dirty: dirty}
+	switch {
+	case version == 0:
+		c.Status = checkFail
+		c.Error = "no migrations applied"
+	case dirty:
+		c.Status = checkFail
+		c.Error = fmt.Sprintf("migration %d failed", version)
+	case !state.Current() && s.schemaMismatch != SchemaMismatchReadOnly && s.schemaMismatch != SchemaMismatchWarn:
+		c.Status = checkFail
+		c.Error = "schema is at " + state.String()
+	}
+	return c
+}
+
+// checkShutdown fails once StartShutdown was called.
+func (s *Server) checkShutdown() checkResult {
+	if s.shuttingDown.Load() {
+		return checkResult{Status: checkFail, Error: "shutting down"}
+	}
+	return checkResult{Status: checkOK}
+}
@@ -0,0 +1,126 @@
+package frontend
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/tetsuo/fortune/internal/database"
+)
+
+// rejectReason tells why an entry of a POST body was not inserted.
+type rejectReason string
+
+const (
+	rejectTooShort    rejectReason = "too_short"
+	rejectTooLong     rejectReason = "too_long"
+	rejectInvalidUTF8 rejectReason = "invalid_utf8"
+	rejectDuplicate   rejectReason = "duplicate"
+)
+
+// uploadReport describes the outcome of a POST request.
+type uploadReport struct {
+	Total      int              `json:"total"`    // number of entries in the body
+	Inserted   int64            `json:"inserted"` // number of entries inserted
+	Rejected   int64            `json:"rejected"` // number of entries not inserted
+	Reasons    map[string]int64 `json:"reasons"`  // number of rejected entries by reason
+	Rejections []rejection      `json:"rejections"`
+}
+
+// rejection is an entry of a POST body that was not inserted.
+type rejection struct {
+	Block  int          `json:"block"` // index of the entry in the body, from 0
+	Line   int          `json:"line"`  // line number where the entry starts, from 1
+	Reason rejectReason `json:"reason"`
+}
+
+// wantsUploadReport reports whether a POST request asks for an
+// uploadReport, with the report query parameter or else by preferring JSON
+// in its Accept header.
+func wantsUploadReport(r *http.Request) (bool, error) {
+	if q := r.URL.Query(); q.Has("report") {
+		return flagParam(q, "report")
+	}
+	return negotiateContentType(r, "text/plain", "application/json") == "application/json", nil
+}
+
+// writeUploadReport writes the uploadReport of u, with the given status.
+// Duplicates that were only detected by the insert are not listed, but
+// counted.
+func writeUploadReport(w http.ResponseWriter, status int, u *upload) error {
+	report := uploadReport{
+		Total:      u.total,
+		Inserted:   u.inserted,
+		Rejected:   int64(u.total) - u.inserted,
+		Reasons:    map[string]int64{},
+		Rejections: make([]rejection, len(u.rejected)),
+	}
+	for i, e := range u.rejected {
+		if e.reason != rejectDuplicate {
+			report.Reasons[string(e.reason)]++
+		}
+		report.Rejections[i] = rejection{Block: e.index, Line: e.line, Reason: e.reason}
+	}
+	if u.duplicates > 0 {
+		report.Reasons[string(rejectDuplicate)] = u.duplicates
+	}
+	// Duplicates are found when their batch is inserted.
+	slices.SortFunc(report.Rejections, func(a, b rejection) int { return cmp.Compare(a.Block, b.Block) })
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept")
+
+	w.WriteHeader(status)
+
+	_, err = w.Write(body)
+
+	return err
+}
+
+// partitionStored splits entries into those whose hash is not stored in
+// the collection yet, and those whose hash is.
+func partitionStored(ctx context.Context, db *database.DB, collectionID int64, entries []*cookieEntry) (fresh, stored []*cookieEntry, err error) {
+	// One parameter is taken by the collection.
+	const chunkSize = database.MaxParameters - 1
+
+	isStored := map[string]bool{}
+	for i := 0; i < len(entries); i += chunkSize {
+		chunk := entries[i:min(i+chunkSize, len(entries))]
+		args := make([]any, 1, 1+len(chunk))
+		args[0] = collectionID
+		for _, e := range chunk {
+			args = append(args, e.hash[:])
+		}
+		query := `SELECT content_hash FROM fortune_cookies WHERE collection_id = ? AND content_hash IN (` +
+			strings.Repeat("?, ", len(chunk)-1) + `?)`
+		err := db.RunQuery(ctx, query, func(rows *sql.Rows) error {
+			var hash []byte
+			if err := rows.Scan(&hash); err != nil {
+				return err
+			}
+			isStored[string(hash)] = true
+			return nil
+		}, args...)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, e := range entries {
+		if isStored[string(e.hash[:])] {
+			stored = append(stored, e)
+		} else {
+			fresh = append(fresh, e)
+		}
+	}
+	return fresh, stored, nil
+}
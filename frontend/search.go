@@ -0,0 +1,205 @@
+package frontend
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tetsuo/fortune/internal/cookiefile"
+	"github.com/tetsuo/fortune/internal/cookieindex"
+)
+
+const (
+	// maxPatternLength is the maximum length of a search pattern, in bytes.
+	maxPatternLength = 256
+
+	// maxPatternSize is the maximum number of instructions of a compiled
+	// search pattern. Go regular expressions run in time linear in the size
+	// of the input, but that time is also proportional to the size of the
+	// pattern, which can be large even for short patterns such as
+	// "(\w{100}){10}".
+	maxPatternSize = 1000
+
+	// maxSearchResults is the maximum number of fortunes returned by a
+	// search.
+	maxSearchResults = 500
+
+	// searchTimeout bounds the time spent scanning fortunes for a search.
+	searchTimeout = 10 * time.Second
+)
+
+// errSearchLimit stops a search once maxSearchResults fortunes matched.
+var errSearchLimit = errors.New("search result limit reached")
+
+// serveSearch handles HTTP GET requests to find the fortunes matching the
+// regular expression in the pattern query parameter, like "fortune -m". The
+// i query parameter makes the match case-insensitive, and the offensive and
+// length query parameters of GET / select the fortunes to search. Matching
+// fortunes are written in the original format of the Unix fortune command,
+// each followed by a line containing only '%'. At most maxSearchResults
+// fortunes are returned; the X-Search-Truncated header is set if there were
+// more. Returns a 400 error if the pattern is missing, invalid or too
+// complex, and a 503 error if the search takes too long.
+func (s *Server) serveSearch(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+
+	foldCase, err := flagParam(q, "i")
+	if err != nil {
+		return &serverError{
+			status:       http.StatusBadRequest,
+			responseText: http.StatusText(http.StatusBadRequest),
+			err:          err,
+		}
+	}
+
+	re, err := compilePattern(q.Get("pattern"), foldCase)
+	if err != nil {
+		return &serverError{
+			status:       http.StatusBadRequest,
+			responseText: http.StatusText(http.StatusBadRequest),
+			err:          err,
+		}
+	}
+
+	filter, err := s.fortuneFilter(r)
+	if err != nil {
+		return &serverError{
+			status:       http.StatusBadRequest,
+			responseText: http.StatusText(http.StatusBadRequest),
+			err:          err,
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), searchTimeout)
+	defer cancel()
+
+	matches, truncated, err := s.searchFortunes(ctx, re, filter)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return &serverError{
+				status:       http.StatusServiceUnavailable,
+				responseText: http.StatusText(http.StatusServiceUnavailable),
+				err:          err,
+			}
+		}
+		// Other errors
+		return err
+	}
+
+	var b strings.Builder
+	for _, text := range matches {
+		b.WriteString(text)
+		b.WriteString("\n%\n")
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Match-Count", strconv.Itoa(len(matches)))
+	if truncated {
+		w.Header().Set("X-Search-Truncated", "true")
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write([]byte(b.String()))
+
+	return err
+}
+
+// compilePattern compiles a search pattern, rejecting patterns that are
+// empty, longer than maxPatternLength or that compile to more than
+// maxPatternSize instructions.
+func compilePattern(pattern string, foldCase bool) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, errors.New("missing pattern")
+	}
+	if len(pattern) > maxPatternLength {
+		return nil, fmt.Errorf("pattern too long (%d > %d bytes)", len(pattern), maxPatternLength)
+	}
+	if foldCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %v", err)
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %v", err)
+	}
+	if n := len(prog.Inst); n > maxPatternSize {
+		return nil, fmt.Errorf("pattern too complex (%d > %d instructions)", n, maxPatternSize)
+	}
+	return regexp.Compile(pattern)
+}
+
+// searchFortunes returns the text of the fortunes matching both re and
+// filter, in id order, and reports whether there were more than
+// maxSearchResults of them. Fortunes are streamed from the database, so
+// the search stops as soon as ctx is done.
+func (s *Server) searchFortunes(ctx context.Context, re *regexp.Regexp, filter cookieindex.Filter) (matches []string, truncated bool, err error) {
+	var (
+		conds []string
+		args  []any
+	)
+	switch filter.Offensive {
+	case cookieindex.ExcludeOffensive:
+		conds = append(conds, "NOT offensive")
+	case cookieindex.OnlyOffensive:
+		conds = append(conds, "offensive")
+	}
+	if filter.MinLength > 0 {
+		conds = append(conds, "length >= ?")
+		args = append(args, filter.MinLength)
+	}
+	if filter.MaxLength > 0 {
+		conds = append(conds, "length <= ?")
+		args = append(args, filter.MaxLength)
+	}
+	query := `SELECT value, offensive FROM fortune_cookies`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+	query += ` ORDER BY id`
+
+	err = s.db.RunQuery(ctx, query, func(rows *sql.Rows) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var (
+			text      string
+			offensive bool
+		)
+		if err := rows.Scan(&text, &offensive); err != nil {
+			return err
+		}
+		if offensive {
+			text = cookiefile.Rot13(text)
+		}
+		if !re.MatchString(text) {
+			return nil
+		}
+		if len(matches) == maxSearchResults {
+			return errSearchLimit
+		}
+		matches = append(matches, text)
+		return nil
+	}, args...)
+	if errors.Is(err, errSearchLimit) {
+		return matches, true, nil
+	}
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			// The driver may report a canceled query in its own way.
+			err = ctxErr
+		}
+		return nil, false, err
+	}
+	return matches, false, nil
+}
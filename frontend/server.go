@@ -2,30 +2,109 @@ package frontend
 
 import (
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"cloud.google.com/go/errorreporting"
+	"github.com/tetsuo/fortune/etc/migrations"
 	"github.com/tetsuo/fortune/internal/database"
 	"go.uber.org/zap"
 )
 
 type Server struct {
-	db  *database.DB
-	log *zap.SugaredLogger
-	er  *errorreporting.Client
+	db    *database.DB // only set once ready
+	ready atomic.Bool
+	log   *zap.SugaredLogger
+	er    *errorreporting.Client
+
+	maxPingLatency  time.Duration
+	latestMigration uint
+	schemaMismatch  string
+	shuttingDown    atomic.Bool
+	readOnly        atomic.Bool
+	checksMu        sync.Mutex        // guards checkStatus
+	checkStatus     map[string]string // last status of each readiness check
+
+	shortLength   int
+	maxUploadSize int64
+
+	indexRefreshInterval time.Duration
+	indexMu              sync.Mutex // serializes loads of the cookie index
+	index                atomic.Pointer[loadedIndex]
+	indexStale           atomic.Bool
 }
 
 func NewServer(cfg Config, db *database.DB, er *errorreporting.Client) (*Server, error) {
-	return &Server{
+	shortLength := cfg.ShortFortuneLength
+	if shortLength <= 0 {
+		shortLength = defaultShortLength
+	}
+	maxUploadSize := cfg.MaxUploadSize
+	if maxUploadSize <= 0 {
+		maxUploadSize = defaultMaxUploadSize
+	}
+	maxPingLatency := cfg.ReadyMaxPingLatency
+	if maxPingLatency <= 0 {
+		maxPingLatency = defaultMaxPingLatency
+	}
+	indexRefreshInterval := cfg.IndexRefreshInterval
+	if indexRefreshInterval <= 0 {
+		indexRefreshInterval = defaultIndexRefreshInterval
+	}
+	latestMigration, err := database.LatestVersion(migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
 		log: zap.S(),
 		er:  er,
 		db:  db,
-	}, nil
+
+		shortLength:   shortLength,
+		maxUploadSize: maxUploadSize,
+
+		maxPingLatency:  maxPingLatency,
+		latestMigration: latestMigration,
+		schemaMismatch:  cfg.SchemaMismatch,
+		checkStatus:     map[string]string{},
+
+		indexRefreshInterval: indexRefreshInterval,
+	}
+	s.ready.Store(db != nil)
+	return s, nil
+}
+
+// SetDB sets the database of a Server created without one, once it is
+// connected. Until then, requests that need the database fail with
+// 503 Service Unavailable.
+func (s *Server) SetDB(db *database.DB) {
+	s.db = db
+	s.ready.Store(true)
+}
+
+// SetReadOnly makes the server reject uploads with 503 Service Unavailable,
+// for example because the database schema isn't the one it was built for.
+func (s *Server) SetReadOnly() {
+	s.readOnly.Store(true)
+}
+
+// Ready reports whether s has a database to serve requests with.
+func (s *Server) Ready() bool {
+	return s.ready.Load()
 }
 
 func (s *Server) Install(handle func(string, http.Handler)) {
 	handle("GET /", s.errorHandler(s.serveGET))
+	handle("GET /{collection}", s.errorHandler(s.serveGET))
+	handle("GET /collections", s.errorHandler(s.serveCollections))
+	handle("GET /fortunes", s.errorHandler(s.serveFullTextSearch))
+	handle("GET /fortunes/{id}", s.errorHandler(s.serveFortune))
+	handle("GET /search", s.errorHandler(s.serveSearch))
 	handle("POST /", s.errorHandler(s.servePOST))
+	handle("POST /{collection}", s.errorHandler(s.servePOST))
 	handle("GET /healthz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}))
+	handle("GET /readyz", http.HandlerFunc(s.serveReadyz))
 }
@@ -0,0 +1,68 @@
+package frontend
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNotReady(t *testing.T) {
+	s, handler, logs := newTestServer(t, nil)
+	if s.Ready() {
+		t.Fatal("server without a database is ready")
+	}
+
+	for _, tt := range []ttest{
+		{
+			name:        "GET",
+			wantStatus:  http.StatusServiceUnavailable,
+			wantLogs:    []wantedLog{{"info", "503 database is not ready"}},
+			wantHeaders: map[string][]string{"Retry-After": {"5"}},
+		},
+		{
+			name:        "POST",
+			method:      http.MethodPost,
+			contentType: "text/plain",
+			body:        []byte("Fortune favors the bold."),
+			wantStatus:  http.StatusServiceUnavailable,
+			wantLogs:    []wantedLog{{"info", "503 database is not ready"}},
+		},
+		{
+			name:       "health check",
+			path:       "/healthz",
+			wantStatus: http.StatusOK,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, handler, logs)
+		})
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	s, handler, logs := newTestServer(t, nil)
+	// Uploads are rejected before the database is used.
+	s.ready.Store(true)
+	s.SetReadOnly()
+
+	for _, tt := range []ttest{
+		{
+			name:        "POST",
+			method:      http.MethodPost,
+			contentType: "text/plain",
+			body:        []byte("Fortune favors the bold."),
+			wantStatus:  http.StatusServiceUnavailable,
+			wantText:    "Service Unavailable\n",
+			wantLogs:    []wantedLog{{"info", "503 server is read-only"}},
+		},
+		{
+			name:       "POST to invalid collection",
+			method:     http.MethodPost,
+			path:       "/Invalid",
+			wantStatus: http.StatusNotFound,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, handler, logs)
+		})
+	}
+}
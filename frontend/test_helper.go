@@ -2,6 +2,7 @@ package frontend
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -58,6 +59,7 @@ type ttest struct {
 	body        []byte
 	wantStatus  int
 	wantText    string
+	wantJSON    map[string]any
 	wantEmpty   bool
 	wantLogs    []wantedLog
 	wantHeaders map[string][]string
@@ -88,6 +90,15 @@ func (tt ttest) run(t *testing.T, handler http.Handler, observedLogs *observer.O
 	if tt.wantText != "" {
 		assert.Equal(t, "text/plain; charset=utf-8", res.Header.Get("Content-type"))
 		assert.Equal(t, tt.wantText, w.Body.String())
+	} else if tt.wantJSON != nil {
+		assert.Equal(t, "application/json", res.Header.Get("Content-type"))
+		var got map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("json.Unmarshal(%q): %v", w.Body.String(), err)
+		}
+		for key, want := range tt.wantJSON {
+			assert.Equal(t, want, got[key], fmt.Sprintf("JSON field %q", key))
+		}
 	} else if tt.wantEmpty {
 		assert.Equal(t, w.Body.Len(), 0)
 	}
@@ -0,0 +1,220 @@
+package frontend
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/tetsuo/fortune/internal/cookiefile"
+	"github.com/tetsuo/fortune/internal/database"
+)
+
+// defaultMaxUploadSize is the maximum size of the body of a POST request,
+// in bytes, unless configured otherwise.
+const defaultMaxUploadSize = 64 << 20
+
+// uploadColumns are the columns of fortune_cookies set by an upload.
+var uploadColumns = []database.Column{"collection_id", "value", "offensive", "length", "content_hash"}
+
+// uploadBatchSize is the number of entries inserted at once by an upload,
+// as many as a single statement of BulkUpsert takes.
+var uploadBatchSize = database.MaxParameters / len(uploadColumns)
+
+// uploadIsAtomic reports whether the fortunes of a POST request are
+// inserted all at once, in a single transaction, which is the default. With
+// the atomic query parameter set to false, the batches inserted before an
+// error are kept.
+func uploadIsAtomic(r *http.Request) (bool, error) {
+	if q := r.URL.Query(); q.Has("atomic") {
+		return flagParam(q, "atomic")
+	}
+	return true, nil
+}
+
+// multipartUpload reads a multipart/form-data POST body, made of a cookie
+// file in a "cookies" part, optionally preceded by its strfile index in a
+// "dat" part. It returns the index, if any, and the reader of the cookie
+// file. Parts after the cookie file are not read.
+func multipartUpload(r io.Reader, boundary string) (*cookiefile.Index, io.Reader, error) {
+	mr := multipart.NewReader(r, boundary)
+	var index *cookiefile.Index
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, nil, errors.New("multipart upload has no cookies part")
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		switch name := p.FormName(); {
+		case name == "dat" && index == nil:
+			if index, err = cookiefile.ReadIndex(p); err != nil {
+				return nil, nil, err
+			}
+		case name == "cookies":
+			return index, p, nil
+		default:
+			return nil, nil, fmt.Errorf("unexpected part %q in multipart upload", name)
+		}
+	}
+}
+
+// errIndexMismatch is returned by an upload whose cookie file does not match
+// the strfile index sent along with it.
+var errIndexMismatch = errors.New("cookie file does not match its index")
+
+// upload inserts the entries of a POST body in batches, as they are parsed.
+type upload struct {
+	db         *database.DB // where to insert, possibly in a transaction
+	collection string       // name of the collection
+	offensive  bool         // whether to store the entries as offensive
+	report     bool         // whether to find and keep the rejected entries
+
+	// index is the strfile index of the cookie file, if one was sent
+	// along with it. The file must have as many entries, and if the index
+	// is rotated the entries are already rot13-encoded.
+	index *cookiefile.Index
+
+	collectionID int64 // set by the first insert
+	batch        []*cookieEntry
+
+	total      int   // number of entries
+	valid      int   // number of entries that were not rejected by decodeBody
+	inserted   int64 // number of entries inserted
+	duplicates int64 // number of entries already stored or repeated
+	rejected   []*cookieEntry
+}
+
+// run parses the cookie file read from r and inserts its entries into db.
+// Since r cannot be read again, the error it returns is wrapped with
+// database.NoRetry.
+func (u *upload) run(ctx context.Context, db *database.DB, r io.Reader) error {
+	u.db = db
+	err := u.checkIndex()
+	if err == nil {
+		// The file is split as strfile does, so that its entries can be
+		// counted against the index.
+		err = decodeBody(r, u.index != nil, func(e *cookieEntry) error {
+			return u.add(ctx, e)
+		})
+	}
+	if err == nil && u.index != nil && uint32(u.total) != u.index.Count {
+		err = fmt.Errorf("%w: %d entries, index has %d", errIndexMismatch, u.total, u.index.Count)
+	}
+	if err == nil {
+		err = u.flush(ctx)
+	}
+	return database.NoRetry(err)
+}
+
+// checkIndex checks that the index of the upload, if any, describes a
+// cookie file that can be stored as requested.
+func (u *upload) checkIndex() error {
+	switch {
+	case u.index == nil:
+		return nil
+	case u.index.Delim != '%':
+		return fmt.Errorf("%w: unsupported delimiter %q", errIndexMismatch, u.index.Delim)
+	case u.index.Rotated() && !u.offensive:
+		return fmt.Errorf("%w: rotated index for inoffensive fortunes", errIndexMismatch)
+	}
+	return nil
+}
+
+// add adds an entry to the upload, inserting the current batch if it is
+// full.
+func (u *upload) add(ctx context.Context, e *cookieEntry) error {
+	u.total++
+	if e.reason != "" {
+		u.reject(e)
+		return nil
+	}
+	u.valid++
+	if u.offensive && (u.index == nil || !u.index.Rotated()) {
+		e.text = cookiefile.Rot13(e.text)
+	}
+	e.hash = sha256.Sum256([]byte(e.text))
+	u.batch = append(u.batch, e)
+	if len(u.batch) < uploadBatchSize {
+		return nil
+	}
+	return u.flush(ctx)
+}
+
+// reject records a rejected entry, if the upload is reported.
+func (u *upload) reject(e *cookieEntry) {
+	if e.reason == rejectDuplicate {
+		u.duplicates++
+	}
+	if u.report {
+		e.text = "" // not reported
+		u.rejected = append(u.rejected, e)
+	}
+}
+
+// flush inserts the current batch. Entries repeated in the batch are
+// skipped, and so are the ones already stored in the collection, which
+// includes those of earlier batches.
+func (u *upload) flush(ctx context.Context) error {
+	if len(u.batch) == 0 {
+		return nil
+	}
+
+	if u.collectionID == 0 {
+		id, err := ensureCollection(ctx, u.db, u.collection)
+		if err != nil {
+			return err
+		}
+		u.collectionID = id
+	}
+
+	seen := make(map[[sha256.Size]byte]bool, len(u.batch))
+	var accepted []*cookieEntry
+	for _, e := range u.batch {
+		if seen[e.hash] {
+			e.reason = rejectDuplicate
+			u.reject(e)
+			continue
+		}
+		seen[e.hash] = true
+		accepted = append(accepted, e)
+	}
+
+	if u.report {
+		// Find the entries that are already stored, so that they can be
+		// reported. The insert below skips them regardless.
+		var (
+			stored []*cookieEntry
+			err    error
+		)
+		if accepted, stored, err = partitionStored(ctx, u.db, u.collectionID, accepted); err != nil {
+			return err
+		}
+		for _, e := range stored {
+			e.reason = rejectDuplicate
+			u.reject(e)
+		}
+	}
+
+	rows := make([]any, 0, len(uploadColumns)*len(accepted))
+	for _, e := range accepted {
+		rows = append(rows, u.collectionID, e.text, u.offensive, len(e.text), e.hash[:])
+	}
+
+	// Fortunes that are already stored in the collection are skipped.
+	ids, err := u.db.BulkUpsert(ctx, "fortune_cookies", uploadColumns, rows, []database.Column{"collection_id", "content_hash"}, nil)
+	if err != nil {
+		return err
+	}
+	n := int64(len(ids))
+	u.inserted += n
+	// Fortunes stored concurrently are only found by the insert.
+	u.duplicates += int64(len(accepted)) - n
+
+	u.batch = u.batch[:0]
+	return nil
+}
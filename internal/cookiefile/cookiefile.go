@@ -0,0 +1,241 @@
+// Package cookiefile reads fortune cookie files, the format of the Unix
+// fortune command, in which cookies are separated by lines containing only
+// a '%' character.
+package cookiefile
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"unicode"
+)
+
+// Cookie is a fortune cookie read from a cookie file.
+type Cookie struct {
+	// Index is the position of the cookie in the file, from 0. Blank
+	// cookies are skipped and not counted, unless the Scanner counts them
+	// as strfile does.
+	Index int
+
+	// Line is the line number where the cookie starts, from 1.
+	Line int
+
+	// Text is the text of the cookie, with leading and trailing white space
+	// removed. It is truncated if the cookie is longer than the maximum
+	// length of the Scanner.
+	Text string
+
+	// Length is the length of the cookie in bytes, even if Text is
+	// truncated.
+	Length int
+}
+
+// Truncated reports whether the text of c was truncated.
+func (c Cookie) Truncated() bool {
+	return c.Length > len(c.Text)
+}
+
+// readerSize is the size of the read buffer of a Scanner. A line longer
+// than that is never considered a separator.
+const readerSize = 64 << 10
+
+// Scanner reads the cookies of a cookie file one at a time. It holds at
+// most one cookie in memory, up to its maximum length, so it can read files
+// of any size.
+type Scanner struct {
+	r       *bufio.Reader
+	maxLen  int
+	strfile bool // whether to split and count cookies as strfile does
+
+	line    int  // number of the line being read
+	partial bool // whether the rest of the line is yet to be read
+	index   int  // index of the next cookie
+	cookie  Cookie
+	err     error
+	done    bool
+
+	// State of the cookie being read.
+	first  int    // line number of the first line, blank or not
+	raw    bool   // whether any line was read, blank or not
+	start  int    // line number of the first non-blank line
+	text   []byte // text so far, without trailing white space
+	space  []byte // white space following text
+	length int    // length of text, even beyond maxLen
+	nspace int    // length of space, even beyond maxLen
+}
+
+// NewScanner returns a Scanner reading from r. The text of cookies longer
+// than maxLen bytes is truncated.
+func NewScanner(r io.Reader, maxLen int) *Scanner {
+	return &Scanner{r: bufio.NewReaderSize(r, readerSize), maxLen: maxLen}
+}
+
+// SetStrfile makes s split and count cookies as strfile does, so that they
+// match the Index written by strfile for the same file: only lines that are
+// exactly "%" separate cookies, and blank cookies are returned, with an
+// empty text, unless there is nothing at all between their separators.
+func (s *Scanner) SetStrfile() {
+	s.strfile = true
+}
+
+// Scan advances the Scanner to the next cookie, which is then available
+// through the Cookie method. It returns false when there are no more
+// cookies, either by reaching the end of the input or an error.
+func (s *Scanner) Scan() bool {
+	for !s.done {
+		line, full, err := s.readLine()
+		if err != nil && !errors.Is(err, io.EOF) {
+			s.err = err
+			s.done = true
+			return false
+		}
+		eof := err != nil
+		if eof && len(line) == 0 {
+			s.done = true
+			if s.pending() {
+				s.emit()
+				return true
+			}
+			return false
+		}
+
+		if !full && s.separator(line, eof) {
+			if s.pending() {
+				s.emit()
+				return true
+			}
+			s.reset()
+			continue
+		}
+
+		if !s.raw {
+			s.raw, s.first = true, s.line
+		}
+		if s.length > 0 || s.nspace > 0 {
+			s.add([]byte("\n"))
+		}
+		s.add(line)
+		// Read the rest of an overlong line.
+		for full {
+			line, full, err = s.readLine()
+			if err != nil && !errors.Is(err, io.EOF) {
+				s.err = err
+				s.done = true
+				return false
+			}
+			s.add(line)
+		}
+	}
+	return false
+}
+
+// Cookie returns the cookie read by the last call to Scan.
+func (s *Scanner) Cookie() Cookie {
+	return s.cookie
+}
+
+// Err returns the first error encountered by the Scanner, other than
+// io.EOF.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// separator reports whether line separates two cookies. The line is the
+// last one of the input, without a line terminator, if eof is true; strfile
+// does not take it for a separator.
+func (s *Scanner) separator(line []byte, eof bool) bool {
+	if s.strfile {
+		return !eof && string(line) == "%"
+	}
+	return string(bytes.TrimSpace(line)) == "%"
+}
+
+// pending reports whether a cookie was read since the last separator.
+func (s *Scanner) pending() bool {
+	return s.length > 0 || s.strfile && s.raw
+}
+
+// readLine returns the next line of input, without its line terminator.
+// If the line doesn't fit in the read buffer, full is true and the next
+// call returns the rest of it. The returned slice is only valid until the
+// next read.
+func (s *Scanner) readLine() (line []byte, full bool, err error) {
+	line, err = s.r.ReadSlice('\n')
+	if len(line) == 0 {
+		return nil, false, err
+	}
+	if !s.partial {
+		s.line++
+	}
+	s.partial = errors.Is(err, bufio.ErrBufferFull)
+	switch {
+	case s.partial:
+		return line, true, nil
+	case err == nil:
+		return line[:len(line)-1], false, nil
+	default:
+		return line, false, err
+	}
+}
+
+// add appends p to the cookie being read, keeping at most maxLen bytes of
+// its text.
+func (s *Scanner) add(p []byte) {
+	if s.length == 0 {
+		// Leading white space is not part of the cookie.
+		p = bytes.TrimLeftFunc(p, unicode.IsSpace)
+		if len(p) == 0 {
+			return
+		}
+		s.start = s.line
+	}
+	body := bytes.TrimRightFunc(p, unicode.IsSpace)
+	if len(body) == 0 {
+		s.keep(&s.space, p, s.length+s.nspace)
+		s.nspace += len(p)
+		return
+	}
+	// The white space read so far is inside the cookie.
+	s.keep(&s.text, s.space, s.length)
+	s.length += s.nspace
+	s.space, s.nspace = s.space[:0], 0
+
+	s.keep(&s.text, body, s.length)
+	s.length += len(body)
+
+	tail := p[len(body):]
+	s.keep(&s.space, tail, s.length)
+	s.nspace = len(tail)
+}
+
+// keep appends to *dst as much of p as fits within maxLen bytes, given the
+// length n of the cookie before p.
+func (s *Scanner) keep(dst *[]byte, p []byte, n int) {
+	if room := s.maxLen - n; room > 0 {
+		*dst = append(*dst, p[:min(len(p), room)]...)
+	}
+}
+
+// emit makes the cookie being read available through the Cookie method.
+func (s *Scanner) emit() {
+	line := s.start
+	if s.length == 0 {
+		line = s.first
+	}
+	s.cookie = Cookie{
+		Index:  s.index,
+		Line:   line,
+		Text:   string(s.text),
+		Length: s.length,
+	}
+	s.index++
+	s.reset()
+}
+
+// reset discards the cookie being read.
+func (s *Scanner) reset() {
+	s.text, s.space = s.text[:0], s.space[:0]
+	s.length, s.nspace = 0, 0
+	s.raw = false
+}
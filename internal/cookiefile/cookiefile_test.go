@@ -0,0 +1,202 @@
+package cookiefile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func scanAll(t *testing.T, r io.Reader, maxLen int) []Cookie {
+	t.Helper()
+	s := NewScanner(r, maxLen)
+	var cookies []Cookie
+	for s.Scan() {
+		cookies = append(cookies, s.Cookie())
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return cookies
+}
+
+func TestScanner(t *testing.T) {
+	long := strings.Repeat("x", 100<<10)
+
+	for _, test := range []struct {
+		name   string
+		input  string
+		maxLen int
+		want   []Cookie
+	}{
+		{
+			name:  "empty",
+			input: "",
+		},
+		{
+			name:  "separators only",
+			input: "%\n%\n  %  \n",
+		},
+		{
+			name:   "one cookie",
+			input:  "hello",
+			maxLen: 100,
+			want:   []Cookie{{Index: 0, Line: 1, Text: "hello", Length: 5}},
+		},
+		{
+			name:   "trailing separator",
+			input:  "hello\n%\n",
+			maxLen: 100,
+			want:   []Cookie{{Index: 0, Line: 1, Text: "hello", Length: 5}},
+		},
+		{
+			name:   "white space",
+			input:  "\n  \n  first\n\n  line  \n\n%\n   \n%\n\tlast\n",
+			maxLen: 100,
+			want: []Cookie{
+				{Index: 0, Line: 3, Text: "first\n\n  line", Length: 13},
+				{Index: 1, Line: 10, Text: "last", Length: 4},
+			},
+		},
+		{
+			name:   "percent signs in text",
+			input:  "100%\n%%\n%\n%done",
+			maxLen: 100,
+			want: []Cookie{
+				{Index: 0, Line: 1, Text: "100%\n%%", Length: 7},
+				{Index: 1, Line: 4, Text: "%done", Length: 5},
+			},
+		},
+		{
+			name:   "carriage returns",
+			input:  "one\r\n%\r\ntwo\r\n",
+			maxLen: 100,
+			want: []Cookie{
+				{Index: 0, Line: 1, Text: "one", Length: 3},
+				{Index: 1, Line: 3, Text: "two", Length: 3},
+			},
+		},
+		{
+			name:   "truncated",
+			input:  "abc def\n%\nabc\n\n\n\n\n\ndef\n%\nabc",
+			maxLen: 5,
+			want: []Cookie{
+				{Index: 0, Line: 1, Text: "abc d", Length: 7},
+				{Index: 1, Line: 3, Text: "abc\n\n", Length: 12},
+				{Index: 2, Line: 11, Text: "abc", Length: 3},
+			},
+		},
+		{
+			name:   "overlong line",
+			input:  "a\n%\n" + long + "\n" + long + "\n%\nb",
+			maxLen: 10,
+			want: []Cookie{
+				{Index: 0, Line: 1, Text: "a", Length: 1},
+				{Index: 1, Line: 3, Text: long[:10], Length: 2*len(long) + 1},
+				{Index: 2, Line: 6, Text: "b", Length: 1},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := scanAll(t, strings.NewReader(test.input), test.maxLen)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+			// Reading one byte at a time makes no difference.
+			got = scanAll(t, iotest.OneByteReader(strings.NewReader(test.input)), test.maxLen)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("one byte at a time: got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestScannerStrfile(t *testing.T) {
+	// A cookie file with a line that is only "%" after trimming, a blank
+	// cookie, an empty one and a line that is "%" followed by a carriage
+	// return, along with the index strfile writes for it.
+	const cookies = "one\n%\n % \n%\n\n%\n%\ntwo\n%\r\nthree\n%\n"
+	dat := []byte{
+		0x00, 0x00, 0x00, 0x02, // version
+		0x00, 0x00, 0x00, 0x04, // count
+		0x00, 0x00, 0x00, 0x0d, // longest
+		0x00, 0x00, 0x00, 0x01, // shortest
+		0x00, 0x00, 0x00, 0x00, // flags
+		'%', 0x00, 0x00, 0x00, // delimiter
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x06,
+		0x00, 0x00, 0x00, 0x0c,
+		0x00, 0x00, 0x00, 0x0f,
+		0x00, 0x00, 0x00, 0x20,
+	}
+	ix, err := ReadIndex(bytes.NewReader(dat))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewScanner(strings.NewReader(cookies), 100)
+	s.SetStrfile()
+	var got []Cookie
+	for s.Scan() {
+		got = append(got, s.Cookie())
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []Cookie{
+		{Index: 0, Line: 1, Text: "one", Length: 3},
+		{Index: 1, Line: 3, Text: "%", Length: 1},
+		{Index: 2, Line: 5, Text: "", Length: 0},
+		{Index: 3, Line: 8, Text: "two\n%\r\nthree", Length: 12},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if uint32(len(got)) != ix.Count {
+		t.Errorf("got %d cookies, index has %d", len(got), ix.Count)
+	}
+
+	// By default, the same file has fewer cookies than its index counts.
+	if got := scanAll(t, strings.NewReader(cookies), 100); len(got) != 3 {
+		t.Errorf("got %d cookies by default, want 3", len(got))
+	}
+}
+
+func TestScannerStrfileLastLine(t *testing.T) {
+	// strfile doesn't take a last line without a line terminator for a
+	// separator.
+	s := NewScanner(strings.NewReader("one\n%"), 100)
+	s.SetStrfile()
+	if !s.Scan() || s.Cookie().Text != "one\n%" {
+		t.Errorf("got %+v, want one\\n%%", s.Cookie())
+	}
+	if s.Scan() {
+		t.Errorf("got %+v, want no more cookies", s.Cookie())
+	}
+}
+
+func TestScannerTruncated(t *testing.T) {
+	if c := (Cookie{Text: "abc", Length: 3}); c.Truncated() {
+		t.Errorf("%+v is truncated", c)
+	}
+	if c := (Cookie{Text: "abc", Length: 4}); !c.Truncated() {
+		t.Errorf("%+v is not truncated", c)
+	}
+}
+
+func TestScannerError(t *testing.T) {
+	errRead := errors.New("read error")
+	s := NewScanner(io.MultiReader(strings.NewReader("one\n%\ntwo\n"), iotest.ErrReader(errRead)), 100)
+	if !s.Scan() || s.Cookie().Text != "one" {
+		t.Fatalf("first Scan: got %+v, want one", s.Cookie())
+	}
+	if s.Scan() {
+		t.Fatalf("second Scan: got %+v, want error", s.Cookie())
+	}
+	if !errors.Is(s.Err(), errRead) {
+		t.Errorf("Err() = %v, want %v", s.Err(), errRead)
+	}
+}
@@ -0,0 +1,149 @@
+package cookiefile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Flags of an Index, as set by the options of strfile.
+const (
+	FlagRandom  = 0x1 // offsets are shuffled, by strfile -r
+	FlagOrdered = 0x2 // offsets are sorted by text, by strfile -o
+	FlagRotated = 0x4 // cookies are rot13-encoded, by strfile -x
+)
+
+// indexVersion is the version of the Index format written by WriteTo, the
+// one of the strfile of the fortune-mod distribution.
+const indexVersion = 2
+
+// indexHeaderSize is the size of the header of an Index, in bytes.
+const indexHeaderSize = 24
+
+// Index is the index of a cookie file, the ".dat" file written by the
+// strfile command of the Unix fortune distribution. It lets fortune pick a
+// cookie at random without reading the whole file.
+//
+// All numbers are stored as big-endian 32-bit integers, so an Index
+// describes files of up to 4 GB.
+type Index struct {
+	Version  uint32
+	Count    uint32 // number of cookies
+	Longest  uint32 // length of the longest cookie, in bytes
+	Shortest uint32 // length of the shortest cookie, in bytes
+	Flags    uint32
+	Delim    byte // first character of separator lines, '%' by default
+
+	// Offsets are the offsets of the cookies in the file, followed by
+	// the offset of the end of the last cookie, in the order given by
+	// Flags. The length of a cookie counts from its offset up to the
+	// separator line that follows it.
+	Offsets []uint32
+}
+
+// Rotated reports whether the cookies of the indexed file are rot13-encoded.
+func (ix *Index) Rotated() bool {
+	return ix.Flags&FlagRotated != 0
+}
+
+// ReadIndex reads an Index written by strfile, or by WriteTo.
+func ReadIndex(r io.Reader) (*Index, error) {
+	br := bufio.NewReader(r)
+	var hdr [indexHeaderSize]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, fmt.Errorf("cookiefile: reading index header: %w", err)
+	}
+	ix := &Index{
+		Version:  binary.BigEndian.Uint32(hdr[0:]),
+		Count:    binary.BigEndian.Uint32(hdr[4:]),
+		Longest:  binary.BigEndian.Uint32(hdr[8:]),
+		Shortest: binary.BigEndian.Uint32(hdr[12:]),
+		Flags:    binary.BigEndian.Uint32(hdr[16:]),
+		Delim:    hdr[20],
+	}
+	if ix.Version < 1 || ix.Version > indexVersion {
+		return nil, fmt.Errorf("cookiefile: unsupported index version %d", ix.Version)
+	}
+
+	// The count is not trusted to size the offsets before reading them.
+	ix.Offsets = make([]uint32, 0, min(int64(ix.Count)+1, 1<<16))
+	var b [4]byte
+	for range int64(ix.Count) + 1 {
+		if _, err := io.ReadFull(br, b[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("cookiefile: reading index offsets: %w", err)
+		}
+		ix.Offsets = append(ix.Offsets, binary.BigEndian.Uint32(b[:]))
+	}
+	return ix, nil
+}
+
+// WriteTo writes ix in the format of strfile.
+func (ix *Index) WriteTo(w io.Writer) (int64, error) {
+	if len(ix.Offsets) != int(ix.Count)+1 {
+		return 0, fmt.Errorf("cookiefile: index has %d offsets for %d cookies", len(ix.Offsets), ix.Count)
+	}
+	buf := make([]byte, indexHeaderSize, indexHeaderSize+4*len(ix.Offsets))
+	binary.BigEndian.PutUint32(buf[0:], ix.Version)
+	binary.BigEndian.PutUint32(buf[4:], ix.Count)
+	binary.BigEndian.PutUint32(buf[8:], ix.Longest)
+	binary.BigEndian.PutUint32(buf[12:], ix.Shortest)
+	binary.BigEndian.PutUint32(buf[16:], ix.Flags)
+	buf[20] = ix.Delim
+	for _, off := range ix.Offsets {
+		buf = binary.BigEndian.AppendUint32(buf, off)
+	}
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+// indexBuilder computes the Index of a cookie file as it is written.
+type indexBuilder struct {
+	ix     Index
+	offset int64 // offset of the next cookie
+}
+
+func newIndexBuilder() *indexBuilder {
+	return &indexBuilder{ix: Index{
+		Version:  indexVersion,
+		Shortest: math.MaxUint32, // as strfile leaves it for empty files
+		Delim:    '%',
+		Offsets:  []uint32{0},
+	}}
+}
+
+// add records a cookie of n bytes, including its final newline, followed
+// by a separator line of sep bytes.
+func (b *indexBuilder) add(n, sep int) error {
+	b.offset += int64(n + sep)
+	if b.offset > math.MaxUint32 {
+		return errors.New("cookiefile: file too large to index")
+	}
+	b.ix.Count++
+	b.ix.Longest = max(b.ix.Longest, uint32(n))
+	b.ix.Shortest = min(b.ix.Shortest, uint32(n))
+	b.ix.Offsets = append(b.ix.Offsets, uint32(b.offset))
+	return nil
+}
+
+// Rot13 applies the ROT13 substitution cipher to the ASCII letters of s,
+// like strfile -x does to the cookies of offensive files, so that they
+// cannot be read by accident. Rot13 is its own inverse.
+func Rot13(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return 'a' + (r-'a'+13)%26
+		case r >= 'A' && r <= 'Z':
+			return 'A' + (r-'A'+13)%26
+		default:
+			return r
+		}
+	}, s)
+}
@@ -0,0 +1,128 @@
+package cookiefile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriterIndex(t *testing.T) {
+	var sb strings.Builder
+	w := NewWriter(&sb)
+	for _, text := range []string{"abc", "Two\nlines", "Hello"} {
+		if err := w.Write(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := w.Index()
+	want := &Index{
+		Version:  2,
+		Count:    3,
+		Longest:  10,
+		Shortest: 4,
+		Delim:    '%',
+		Offsets:  []uint32{0, 6, 18, 26},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	// The offsets point at the cookies, and the last one at the end.
+	for i, off := range got.Offsets[:got.Count] {
+		if !strings.HasPrefix(sb.String()[off:], []string{"abc", "Two", "Hello"}[i]) {
+			t.Errorf("offset %d: %d points at %q", i, off, sb.String()[off:])
+		}
+	}
+	if n := got.Offsets[got.Count]; n != uint32(sb.Len()) {
+		t.Errorf("last offset: got %d, want %d", n, sb.Len())
+	}
+}
+
+func TestWriterRotated(t *testing.T) {
+	var sb strings.Builder
+	w := NewWriter(&sb)
+	w.SetRotated()
+	if err := w.Write("Hello, World!"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Uryyb, Jbeyq!\n%\n"; sb.String() != want {
+		t.Errorf("got %q, want %q", sb.String(), want)
+	}
+	if ix := w.Index(); !ix.Rotated() || ix.Count != 1 {
+		t.Errorf("got index %+v, want a rotated index of 1 cookie", ix)
+	}
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	for _, ix := range []*Index{
+		{Version: 2, Shortest: 1<<32 - 1, Delim: '%', Offsets: []uint32{0}},
+		{Version: 2, Count: 2, Longest: 7, Shortest: 3, Flags: FlagRotated, Delim: '%', Offsets: []uint32{0, 5, 14}},
+		{Version: 1, Count: 1, Longest: 1, Shortest: 1, Flags: FlagRandom, Delim: '#', Offsets: []uint32{0, 3}},
+	} {
+		var buf bytes.Buffer
+		n, err := ix.WriteTo(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := int64(24 + 4*len(ix.Offsets)); n != want || int64(buf.Len()) != want {
+			t.Errorf("WriteTo wrote %d bytes, buffer has %d, want %d", n, buf.Len(), want)
+		}
+		got, err := ReadIndex(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, ix) {
+			t.Errorf("read back %+v, want %+v", got, ix)
+		}
+	}
+}
+
+func TestReadIndexErrors(t *testing.T) {
+	var buf bytes.Buffer
+	ix := &Index{Version: 2, Count: 2, Longest: 4, Shortest: 4, Delim: '%', Offsets: []uint32{0, 6, 12}}
+	if _, err := ix.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	for _, n := range []int{0, 10, 24, 30, len(data) - 1} {
+		if _, err := ReadIndex(bytes.NewReader(data[:n])); !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			t.Errorf("ReadIndex of %d bytes: got error %v, want EOF", n, err)
+		}
+	}
+
+	bad := bytes.Clone(data)
+	bad[3] = 3 // version
+	if _, err := ReadIndex(bytes.NewReader(bad)); err == nil {
+		t.Error("ReadIndex of version 3: got no error")
+	}
+
+	ix.Count = 3
+	if _, err := ix.WriteTo(io.Discard); err == nil {
+		t.Error("WriteTo with missing offsets: got no error")
+	}
+}
+
+func TestRot13(t *testing.T) {
+	for _, test := range []struct{ in, want string }{
+		{"", ""},
+		{"Hello, World!", "Uryyb, Jbeyq!"},
+		{"abcxyzABCXYZ 123 ü", "nopklmNOPKLM 123 ü"},
+	} {
+		if got := Rot13(test.in); got != test.want {
+			t.Errorf("Rot13(%q) = %q, want %q", test.in, got, test.want)
+		}
+		if got := Rot13(Rot13(test.in)); got != test.in {
+			t.Errorf("Rot13(Rot13(%q)) = %q", test.in, got)
+		}
+	}
+}
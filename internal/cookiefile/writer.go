@@ -0,0 +1,96 @@
+package cookiefile
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidCookie is returned by Writer.Write for a cookie that would not
+// be read back by a Scanner: one that is blank, or that contains a line
+// read as a separator.
+var ErrInvalidCookie = errors.New("cookiefile: cookie is blank or contains a separator line")
+
+// separator is the line written after each cookie.
+const separator = "%\n"
+
+// Writer writes cookie files, following each cookie with a '%' line, like
+// the cookie files of the Unix fortune command. A Scanner reads them back,
+// with leading and trailing white space removed. The Index of the file is
+// computed as it is written.
+type Writer struct {
+	w       *bufio.Writer
+	err     error
+	rotated bool
+	index   *indexBuilder
+}
+
+// NewWriter returns a Writer writing to w. Writes are buffered; call Flush
+// when done.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w), index: newIndexBuilder()}
+}
+
+// SetRotated makes w rot13-encode the cookies it writes, and flag its Index
+// as rotated, like strfile -x. It must be called before Write.
+func (w *Writer) SetRotated() {
+	w.rotated = true
+}
+
+// Index returns the index of the cookies written so far, to be written
+// along with the file, with the same name and a ".dat" extension.
+func (w *Writer) Index() *Index {
+	ix := w.index.ix
+	ix.Offsets = slices.Clone(ix.Offsets)
+	if w.rotated {
+		ix.Flags |= FlagRotated
+	}
+	return &ix
+}
+
+// Write writes a cookie. It returns ErrInvalidCookie, and writes nothing, if
+// text would not be read back as a single cookie.
+func (w *Writer) Write(text string) error {
+	if w.err != nil {
+		return w.err
+	}
+	if !validCookie(text) {
+		return ErrInvalidCookie
+	}
+	if w.rotated {
+		text = Rot13(text)
+	}
+	if w.err = w.index.add(len(text)+1, len(separator)); w.err != nil {
+		return w.err
+	}
+	_, w.err = w.w.WriteString(text)
+	if w.err == nil {
+		_, w.err = w.w.WriteString("\n" + separator)
+	}
+	return w.err
+}
+
+// Flush writes any buffered data to the underlying io.Writer.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.w.Flush()
+	return w.err
+}
+
+// validCookie reports whether text is read back by a Scanner as a single
+// cookie.
+func validCookie(text string) bool {
+	if strings.TrimSpace(text) == "" {
+		return false
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "%" {
+			return false
+		}
+	}
+	return true
+}
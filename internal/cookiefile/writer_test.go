@@ -0,0 +1,46 @@
+package cookiefile
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	texts := []string{
+		"Fortune favors the bold.",
+		"Two\nlines",
+		"Percent signs, like 100%,\n% and %%\nare fine.",
+		"Ünïcödé",
+	}
+
+	var sb strings.Builder
+	w := NewWriter(&sb)
+	for _, text := range texts {
+		if err := w.Write(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, text := range []string{"", " \n\t", "a\n%\nb", "a\n  %  \nb", "%"} {
+		if err := w.Write(text); !errors.Is(err, ErrInvalidCookie) {
+			t.Errorf("Write(%q): got error %v, want %v", text, err, ErrInvalidCookie)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := strings.Join(texts, "\n%\n") + "\n%\n"; sb.String() != want {
+		t.Errorf("got %q, want %q", sb.String(), want)
+	}
+
+	cookies := scanAll(t, strings.NewReader(sb.String()), 1<<10)
+	if len(cookies) != len(texts) {
+		t.Fatalf("read back %d cookies, want %d", len(cookies), len(texts))
+	}
+	for i, c := range cookies {
+		if c.Text != texts[i] {
+			t.Errorf("cookie %d: read back %q, want %q", i, c.Text, texts[i])
+		}
+	}
+}
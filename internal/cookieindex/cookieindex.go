@@ -0,0 +1,219 @@
+// Package cookieindex provides an in-memory index of fortune cookie ids for
+// uniform random selection.
+//
+// Selecting a row with SQL such as
+//
+//	WHERE id >= FLOOR(RAND() * MAX(id)) + 1 ORDER BY id LIMIT 1
+//
+// favors the rows that follow gaps in the id sequence: a row is chosen with
+// probability proportional to the distance from its predecessor. An Index
+// instead picks a position uniformly from the dense list of existing ids, so
+// every id is equally likely regardless of how the ids are distributed.
+package cookieindex
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+	"sort"
+)
+
+// Entry describes a single fortune cookie.
+type Entry struct {
+	ID         int64
+	Collection int64
+	Offensive  bool
+	Length     int
+}
+
+// OffensiveMode determines which entries a Filter matches depending on
+// whether they are offensive.
+type OffensiveMode int
+
+const (
+	// ExcludeOffensive matches inoffensive entries only. This is what the
+	// Unix fortune command does by default.
+	ExcludeOffensive OffensiveMode = iota
+
+	// IncludeOffensive matches all entries, like "fortune -a".
+	IncludeOffensive
+
+	// OnlyOffensive matches offensive entries only, like "fortune -o".
+	OnlyOffensive
+)
+
+// matches reports whether an entry that is offensive or not is matched.
+func (m OffensiveMode) matches(offensive bool) bool {
+	switch m {
+	case IncludeOffensive:
+		return true
+	case OnlyOffensive:
+		return offensive
+	default:
+		return !offensive
+	}
+}
+
+// Filter restricts the entries considered by Pick and Count. The zero Filter
+// matches every inoffensive entry.
+type Filter struct {
+	// Collection, if non-zero, matches only entries in that collection.
+	Collection int64
+
+	// Offensive determines whether offensive entries are matched.
+	Offensive OffensiveMode
+
+	// MinLength matches only entries at least this long.
+	MinLength int
+
+	// MaxLength, if non-zero, matches only entries at most this long.
+	MaxLength int
+}
+
+// bucket identifies a group of entries that a Filter either matches or not,
+// apart from their length.
+type bucket struct {
+	collection int64
+	offensive  bool
+}
+
+// group holds the entries of a bucket, sorted by length, so that the
+// entries within a range of lengths are contiguous.
+type group struct {
+	ids     []int64
+	lengths []int
+}
+
+// span returns the ids of the entries of g with lengths in [minLen, maxLen].
+// A maxLen of zero means there is no upper bound.
+func (g *group) span(minLen, maxLen int) []int64 {
+	lo := sort.SearchInts(g.lengths, minLen)
+	hi := len(g.lengths)
+	if maxLen > 0 {
+		hi = sort.SearchInts(g.lengths, maxLen+1)
+	}
+	if lo >= hi {
+		return nil
+	}
+	return g.ids[lo:hi]
+}
+
+// Index is an immutable set of fortune cookie entries. It is safe for
+// concurrent use.
+type Index struct {
+	entries []Entry // sorted by ID
+
+	// Entries grouped by bucket, so that a filtered subset can be addressed
+	// without scanning every entry.
+	keys   []bucket // sorted keys of groups
+	groups map[bucket]*group
+}
+
+// New returns an Index containing the given entries. If several entries have
+// the same id, only one of them is kept.
+func New(entries []Entry) *Index {
+	entries = slices.Clone(entries)
+	slices.SortStableFunc(entries, func(a, b Entry) int { return cmp.Compare(a.ID, b.ID) })
+	entries = slices.CompactFunc(entries, func(a, b Entry) bool { return a.ID == b.ID })
+
+	byLength := slices.Clone(entries)
+	slices.SortStableFunc(byLength, func(a, b Entry) int { return cmp.Compare(a.Length, b.Length) })
+
+	x := &Index{entries: entries, groups: map[bucket]*group{}}
+	for _, e := range byLength {
+		k := bucket{collection: e.Collection, offensive: e.Offensive}
+		g, ok := x.groups[k]
+		if !ok {
+			g = &group{}
+			x.groups[k] = g
+			x.keys = append(x.keys, k)
+		}
+		g.ids = append(g.ids, e.ID)
+		g.lengths = append(g.lengths, e.Length)
+	}
+	slices.SortFunc(x.keys, func(a, b bucket) int {
+		if c := cmp.Compare(a.collection, b.collection); c != 0 {
+			return c
+		}
+		return cmp.Compare(boolToInt(a.offensive), boolToInt(b.offensive))
+	})
+	return x
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Len returns the number of entries in the index.
+func (x *Index) Len() int {
+	return len(x.entries)
+}
+
+// Lookup returns the entry with the given id, if it is in the index.
+func (x *Index) Lookup(id int64) (Entry, bool) {
+	i, found := slices.BinarySearchFunc(x.entries, id, func(e Entry, id int64) int { return cmp.Compare(e.ID, id) })
+	if !found {
+		return Entry{}, false
+	}
+	return x.entries[i], true
+}
+
+// Count returns the number of entries matching f.
+func (x *Index) Count(f Filter) int {
+	n := 0
+	for _, ids := range x.candidates(f) {
+		n += len(ids)
+	}
+	return n
+}
+
+// Pick returns the id of an entry chosen uniformly at random among the
+// entries matching f, using r as the source of randomness. If r is nil, the
+// top-level functions of math/rand/v2 are used. It returns false if no entry
+// matches f.
+//
+// Pick takes time proportional to the number of collections and the
+// logarithm of the number of entries, regardless of how many entries match.
+func (x *Index) Pick(r *rand.Rand, f Filter) (int64, bool) {
+	candidates := x.candidates(f)
+	n := 0
+	for _, ids := range candidates {
+		n += len(ids)
+	}
+	if n == 0 {
+		return 0, false
+	}
+	var i int
+	if r == nil {
+		i = rand.IntN(n)
+	} else {
+		i = r.IntN(n)
+	}
+	for _, ids := range candidates {
+		if i < len(ids) {
+			return ids[i], true
+		}
+		i -= len(ids)
+	}
+	panic("unreachable")
+}
+
+// candidates returns the ids matching f, in contiguous runs.
+func (x *Index) candidates(f Filter) [][]int64 {
+	var runs [][]int64
+	for _, k := range x.keys {
+		if f.Collection != 0 && k.collection != f.Collection {
+			continue
+		}
+		if !f.Offensive.matches(k.offensive) {
+			continue
+		}
+		if ids := x.groups[k].span(f.MinLength, f.MaxLength); len(ids) > 0 {
+			runs = append(runs, ids)
+		}
+	}
+	return runs
+}
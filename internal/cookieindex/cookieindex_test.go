@@ -0,0 +1,180 @@
+package cookieindex
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// seededCorpus returns n distinct ids in [1, maxID] with irregular gaps, as
+// left behind by deletes, failed bulk inserts and auto-increment jumps.
+func seededCorpus(r *rand.Rand, n int, maxID int64) []int64 {
+	seen := map[int64]bool{}
+	var ids []int64
+	for len(ids) < n {
+		id := r.Int64N(maxID) + 1
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// entries assigns ids round-robin to collections 1 through n, marks every
+// fifth id as offensive, and gives each a random length.
+func entries(r *rand.Rand, ids []int64, n int) []Entry {
+	es := make([]Entry, len(ids))
+	for i, id := range ids {
+		es[i] = Entry{ID: id, Collection: int64(i%n) + 1, Offensive: i%5 == 0, Length: 3 + r.IntN(400)}
+	}
+	return es
+}
+
+// matches reports whether f matches e.
+func matches(f Filter, e Entry) bool {
+	return (f.Collection == 0 || e.Collection == f.Collection) &&
+		f.Offensive.matches(e.Offensive) &&
+		e.Length >= f.MinLength &&
+		(f.MaxLength == 0 || e.Length <= f.MaxLength)
+}
+
+// chiSquare returns the chi-squared statistic of the observed counts against a
+// uniform distribution over ids.
+func chiSquare(counts map[int64]int, ids []int64, draws int) float64 {
+	expected := float64(draws) / float64(len(ids))
+	var stat float64
+	for _, id := range ids {
+		d := float64(counts[id]) - expected
+		stat += d * d / expected
+	}
+	return stat
+}
+
+// chiSquareCritical approximates the critical value of the chi-squared
+// distribution with df degrees of freedom at a significance level of 0.001,
+// using the Wilson–Hilferty transformation.
+func chiSquareCritical(df int) float64 {
+	const z = 3.0902 // standard normal quantile for p = 0.999
+	k := float64(df)
+	return k * math.Pow(1-2/(9*k)+z*math.Sqrt(2/(9*k)), 3)
+}
+
+func TestPickUniform(t *testing.T) {
+	const (
+		corpusSize = 300
+		maxID      = 5000
+	)
+	r := rand.New(rand.NewPCG(1, 2))
+	ids := seededCorpus(r, corpusSize, maxID)
+	x := New(entries(r, ids, 3))
+
+	for _, f := range []Filter{
+		{},
+		{Collection: 2},
+		{Offensive: IncludeOffensive},
+		{Collection: 3, Offensive: OnlyOffensive},
+		{MaxLength: 160},
+		{Collection: 1, MinLength: 161, Offensive: IncludeOffensive},
+	} {
+		var want []int64
+		for _, id := range ids {
+			if e, _ := x.Lookup(id); matches(f, e) {
+				want = append(want, id)
+			}
+		}
+
+		counts := map[int64]int{}
+		n := len(want) * 500
+		for range n {
+			id, ok := x.Pick(r, f)
+			if !ok {
+				t.Fatalf("Pick(%+v) returned false on a non-empty subset", f)
+			}
+			if _, ok := slices.BinarySearch(want, id); !ok {
+				t.Fatalf("Pick(%+v) = %d, which does not match the filter", f, id)
+			}
+			counts[id]++
+		}
+
+		stat := chiSquare(counts, want, n)
+		if crit := chiSquareCritical(len(want) - 1); stat > crit {
+			t.Errorf("Pick(%+v): chi-squared = %.1f, want <= %.1f: distribution is not uniform", f, stat, crit)
+		}
+	}
+}
+
+// TestGapBiasDetected checks that the uniformity test above is able to detect
+// the bias of picking the first id at or after a random point in [1, max(id)].
+func TestGapBiasDetected(t *testing.T) {
+	const (
+		corpusSize = 200
+		maxID      = 5000
+		draws      = corpusSize * 500
+	)
+	r := rand.New(rand.NewPCG(1, 2))
+	ids := seededCorpus(r, corpusSize, maxID)
+	last := ids[len(ids)-1]
+
+	counts := map[int64]int{}
+	for range draws {
+		target := r.Int64N(last) + 1
+		i, _ := slices.BinarySearch(ids, target)
+		counts[ids[i]]++
+	}
+
+	stat := chiSquare(counts, ids, draws)
+	if crit := chiSquareCritical(corpusSize - 1); stat <= crit {
+		t.Errorf("chi-squared = %.1f, want > %.1f: gap bias went undetected", stat, crit)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	x := New([]Entry{{5, 1, false, 10}, {3, 2, false, 20}, {3, 2, false, 20}, {9, 1, false, 30}})
+	if got, want := x.Len(), 3; got != want {
+		t.Errorf("Len() = %d, want %d", got, want)
+	}
+	if e, ok := x.Lookup(3); !ok || e.Collection != 2 {
+		t.Errorf("Lookup(3) = %+v, %t; want collection 2, true", e, ok)
+	}
+	if _, ok := x.Lookup(4); ok {
+		t.Error("Lookup(4) = true, want false")
+	}
+	if got, want := x.Count(Filter{Collection: 1}), 2; got != want {
+		t.Errorf("Count(collection 1) = %d, want %d", got, want)
+	}
+
+	if id, ok := x.Pick(nil, Filter{Collection: 2}); !ok || id != 3 {
+		t.Errorf("Pick(collection 2) = %d, %t; want 3, true", id, ok)
+	}
+	if _, ok := x.Pick(nil, Filter{Collection: 4}); ok {
+		t.Error("Pick(collection 4) returned true for an empty collection")
+	}
+
+	z := New([]Entry{{1, 1, false, 100}, {2, 1, true, 200}, {3, 2, true, 300}, {4, 1, false, 160}})
+	for _, test := range []struct {
+		filter Filter
+		want   int
+	}{
+		{Filter{}, 2},
+		{Filter{Offensive: IncludeOffensive}, 4},
+		{Filter{Offensive: OnlyOffensive}, 2},
+		{Filter{Collection: 2}, 0},
+		{Filter{Collection: 2, Offensive: OnlyOffensive}, 1},
+		{Filter{MaxLength: 160}, 2},
+		{Filter{MaxLength: 159}, 1},
+		{Filter{MinLength: 161}, 0},
+		{Filter{MinLength: 161, Offensive: IncludeOffensive}, 2},
+		{Filter{MinLength: 150, MaxLength: 250, Offensive: IncludeOffensive}, 2},
+	} {
+		if got := z.Count(test.filter); got != test.want {
+			t.Errorf("Count(%+v) = %d, want %d", test.filter, got, test.want)
+		}
+	}
+
+	if _, ok := New(nil).Pick(nil, Filter{}); ok {
+		t.Error("Pick() on an empty index returned true")
+	}
+}
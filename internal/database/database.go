@@ -3,25 +3,34 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"math/rand/v2"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/tetsuo/fortune/internal/wraperr"
+	"go.opencensus.io/trace"
 	"go.uber.org/zap"
 )
 
 // DB wraps a sql.DB. It enhances the original sql.DB by requiring a context argument
-// and logging queries with errors.
+// and logging queries with errors. A DB passed to the function of Transact
+// runs its statements in that transaction.
 type DB struct {
 	db         *sql.DB
 	instanceID string
+	tx         *sql.Tx
+	replicas   *replicaPool // nil if reads go to the primary
 	logger     *zap.SugaredLogger
 }
 
-// Open creates a new DB connection.
-func Open(driverName, dsn, instanceID string) (_ *DB, err error) {
+// Open creates a new DB connection, and checks that the database is
+// reachable, giving up after 30 seconds or when ctx is done.
+func Open(ctx context.Context, driverName, dsn, instanceID string) (_ *DB, err error) {
 	defer wraperr.Wrap(&err, "database.Open(%q, %q)",
 		driverName, redactPassword(dsn))
 
@@ -30,10 +39,11 @@ func Open(driverName, dsn, instanceID string) (_ *DB, err error) {
 		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	if err = db.PingContext(ctx); err != nil {
+		db.Close()
 		return nil, err
 	}
 
@@ -45,11 +55,22 @@ func New(db *sql.DB, instanceID string) *DB {
 	return &DB{db: db, instanceID: instanceID, logger: zap.S()}
 }
 
-// Close closes the database connection.
+// Close closes the database connection, and those to the read replicas.
 func (db *DB) Close() error {
+	if db.replicas != nil {
+		if err := db.replicas.close(); err != nil {
+			db.db.Close()
+			return err
+		}
+	}
 	return db.db.Close()
 }
 
+// InTransaction reports whether db is running in a transaction.
+func (db *DB) InTransaction() bool {
+	return db.tx != nil
+}
+
 // Exec executes a SQL statement and returns the number of rows affected.
 func (db *DB) Exec(ctx context.Context, query string, args ...any) (_ int64, err error) {
 	defer logQuery(ctx, db.logger, query, args, db.instanceID)(&err)
@@ -66,39 +87,339 @@ func (db *DB) Exec(ctx context.Context, query string, args ...any) (_ int64, err
 
 // execResult executes a SQL statement and returns a sql.Result.
 func (db *DB) execResult(ctx context.Context, query string, args ...any) (res sql.Result, err error) {
+	if db.tx != nil {
+		return db.tx.ExecContext(ctx, query, args...)
+	}
 	return db.db.ExecContext(ctx, query, args...)
 }
 
-// QueryRow runs the query and returns a single row.
+// QueryRow runs the query and returns a single row. Outside of a
+// transaction, it runs on a read replica, if any, unless ctx comes from
+// WithPrimary.
 func (db *DB) QueryRow(ctx context.Context, query string, args ...any) *sql.Row {
 	defer logQuery(ctx, db.logger, query, args, db.instanceID)(nil)
-	return db.db.QueryRowContext(ctx, query, args...)
+	if db.tx != nil {
+		return db.tx.QueryRowContext(ctx, query, args...)
+	}
+	return db.reader(ctx).QueryRowContext(ctx, query, args...)
+}
+
+// Query runs the query and returns its rows, which must be closed. Like
+// QueryRow, it runs on a read replica, if any.
+func (db *DB) Query(ctx context.Context, query string, args ...any) (_ *sql.Rows, err error) {
+	defer logQuery(ctx, db.logger, query, args, db.instanceID)(&err)
+	if db.tx != nil {
+		return db.tx.QueryContext(ctx, query, args...)
+	}
+	return db.reader(ctx).QueryContext(ctx, query, args...)
+}
+
+// RunQuery executes query, then calls f on each row. It stops when there are
+// no more rows or f returns a non-nil error.
+func (db *DB) RunQuery(ctx context.Context, query string, f func(*sql.Rows) error, args ...any) error {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	_, err = processRows(rows, f)
+	return err
+}
+
+// Transact executes the given function in the context of a SQL transaction
+// with the given options, or the default ones if opts is nil. The
+// transaction is committed if txFunc returns nil, and rolled back
+// otherwise, or if it panics.
+//
+// The DB passed to txFunc must be used for all statements of the
+// transaction; db itself keeps running statements outside of it.
+//
+// A transaction that fails with a deadlock or a lock wait timeout is
+// retried with a jittered exponential backoff, up to maxTransactAttempts
+// times in all. So txFunc may run more than once, unless it wraps its
+// errors with NoRetry.
+func (db *DB) Transact(ctx context.Context, opts *sql.TxOptions, txFunc func(*DB) error) (err error) {
+	if opts == nil {
+		opts = &sql.TxOptions{}
+	}
+	defer wraperr.Wrap(&err, "Transact(%s)", opts.Isolation)
+
+	if db.InTransaction() {
+		return errors.New("a DB Transact function was called on a DB already in a transaction")
+	}
+
+	ctx, span := trace.StartSpan(ctx, "database.Transact")
+	defer span.End()
+
+	delay := minRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = db.transact(ctx, opts, txFunc)
+		if err == nil || !isRetryable(err) || attempt == maxTransactAttempts {
+			span.AddAttributes(trace.Int64Attribute("attempts", int64(attempt)))
+			if err != nil {
+				span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
+			}
+			return err
+		}
+
+		// Full jitter keeps concurrent transactions that deadlocked on
+		// each other from retrying in lockstep.
+		d := rand.N(delay)
+		db.logger.Debugf("transaction attempt %d failed, retrying in %s: %v", attempt, d, err)
+		span.Annotatef(nil, "retrying after %v", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(d):
+		}
+		delay = min(2*delay, maxRetryDelay)
+	}
+}
+
+// transact runs txFunc in a single transaction.
+func (db *DB) transact(ctx context.Context, opts *sql.TxOptions, txFunc func(*DB) error) (err error) {
+	conn, err := db.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	tx, err := conn.BeginTx(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("conn.BeginTx(): %w", err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else if txErr := tx.Commit(); txErr != nil {
+			err = fmt.Errorf("tx.Commit(): %w", txErr)
+		}
+	}()
+
+	dbtx := New(db.db, db.instanceID)
+	dbtx.tx = tx
+	dbtx.logger = db.logger
+	if err := txFunc(dbtx); err != nil {
+		return fmt.Errorf("txFunc(tx): %w", err)
+	}
+	return nil
 }
 
-const OnConflictDoNothing = "ON DUPLICATE KEY UPDATE colA = colA"
+// maxTransactAttempts is the maximum number of times Transact runs a
+// transaction that keeps failing with retryable errors.
+const maxTransactAttempts = 5
+
+// Bounds of the delay before retrying a transaction, which doubles with
+// each attempt.
+const (
+	minRetryDelay = 10 * time.Millisecond
+	maxRetryDelay = time.Second
+)
+
+// MySQL error numbers of the failures that are resolved by running the
+// transaction again.
+const (
+	errLockWaitTimeout = 1205 // ER_LOCK_WAIT_TIMEOUT
+	errLockDeadlock    = 1213 // ER_LOCK_DEADLOCK
+)
 
-// BulkInsert performs a multi-value insert.
-func (db *DB) BulkInsert(ctx context.Context, table string, columns []string, values []any, conflictAction string) (err error) {
+// isRetryable reports whether err is a deadlock or a lock wait timeout,
+// that is not wrapped with NoRetry.
+func isRetryable(err error) bool {
+	var nr *noRetryError
+	if errors.As(err, &nr) {
+		return false
+	}
+	var myErr *mysql.MySQLError
+	if !errors.As(err, &myErr) {
+		return false
+	}
+	return myErr.Number == errLockDeadlock || myErr.Number == errLockWaitTimeout
+}
+
+// NoRetry wraps err so that Transact returns it rather than retrying the
+// transaction, for functions that cannot run more than once, such as those
+// consuming a stream.
+func NoRetry(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &noRetryError{err}
+}
+
+type noRetryError struct {
+	err error
+}
+
+func (e *noRetryError) Error() string { return e.err.Error() }
+func (e *noRetryError) Unwrap() error { return e.err }
+
+// OnConflictDoNothing is a conflict action that skips the rows that would
+// violate a unique index. MySQL has no such clause, so buildInsertQuery
+// replaces it with a no-op ON DUPLICATE KEY UPDATE of the first inserted
+// column, like BulkUpsert without update columns. Unlike INSERT IGNORE,
+// this does not hide other errors.
+const OnConflictDoNothing = "ON CONFLICT DO NOTHING"
+
+// BulkInsert performs a multi-value insert. Unless db is already in a
+// transaction, it runs in one of its own, so that either all of the values
+// are inserted or none of them.
+func (db *DB) BulkInsert(ctx context.Context, table Table, columns []Column, values []any, conflictAction string) (err error) {
 	defer wraperr.Wrap(&err, "DB.BulkInsert(ctx, %q, %v, [%d values], %q)",
 		table, columns, len(values), conflictAction)
 
-	return db.bulkInsert(ctx, table, columns, nil, values, conflictAction, nil)
+	_, err = db.bulkInsert(ctx, table, columns, values, conflictAction, nil)
+	return err
 }
 
-// bulkInsert performs batched inserts.
-func (db *DB) bulkInsert(ctx context.Context, table string, columns, returningColumns []string, values []any, conflictAction string, scanFunc func(*sql.Rows) error) (err error) {
+// BulkInsertCount is like BulkInsert, but also returns the number of rows
+// inserted. With OnConflictDoNothing, skipped rows are not counted.
+func (db *DB) BulkInsertCount(ctx context.Context, table Table, columns []Column, values []any, conflictAction string) (_ int64, err error) {
+	defer wraperr.Wrap(&err, "DB.BulkInsertCount(ctx, %q, %v, [%d values], %q)",
+		table, columns, len(values), conflictAction)
+
+	return db.bulkInsert(ctx, table, columns, values, conflictAction, nil)
+}
+
+// BulkInsertReturningIDs is like BulkInsert without a conflict action, but
+// returns the AUTO_INCREMENT ids of the inserted rows, in order.
+//
+// The ids of each statement are derived from LAST_INSERT_ID(), which is
+// the id of its first row, and the number of rows affected. This relies on
+// InnoDB allocating consecutive ids to the rows of an insert whose number
+// of rows is known in advance.
+func (db *DB) BulkInsertReturningIDs(ctx context.Context, table Table, columns []Column, values []any) (ids []int64, err error) {
+	defer wraperr.Wrap(&err, "DB.BulkInsertReturningIDs(ctx, %q, %v, [%d values])",
+		table, columns, len(values))
+
+	ids = make([]int64, 0, len(values)/max(len(columns), 1))
+	if _, err := db.bulkInsert(ctx, table, columns, values, "", &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// BulkUpsert performs a multi-value insert, updating the rows that conflict
+// with existing ones on the unique key made of conflictColumns. The
+// updateColumns of those rows are set to the inserted values; if there are
+// none, the rows are left unchanged, as with OnConflictDoNothing.
+//
+// MySQL updates the row on a violation of any unique key of the table, not
+// only the given one, so tables with several unique keys should be upserted
+// with care.
+//
+// It returns the AUTO_INCREMENT ids of the rows it inserted, in order, but
+// not those of the rows it updated or left unchanged. The table's
+// AUTO_INCREMENT column must be named id.
+//
+// Like BulkInsertReturningIDs, this relies on InnoDB allocating consecutive
+// ids to the rows of each statement, starting from LAST_INSERT_ID(), the id
+// of its first inserted row; the ids of conflicting rows are allocated but
+// not used. The rows with ids in that range, and above the largest id
+// before the statement, are selected after each statement, and checked
+// against the number of rows affected, which MySQL counts as 1 for each
+// inserted row and 2 for each updated one.
+func (db *DB) BulkUpsert(ctx context.Context, table Table, columns []Column, values []any, conflictColumns, updateColumns []Column) (ids []int64, err error) {
+	defer wraperr.Wrap(&err, "DB.BulkUpsert(ctx, %q, %v, [%d values], %v, %v)",
+		table, columns, len(values), conflictColumns, updateColumns)
+
+	conflictAction, err := buildUpsertClause(columns, conflictColumns, updateColumns)
+	if err != nil {
+		return nil, err
+	}
+	ids = []int64{}
+	if _, err := db.bulkInsert(ctx, table, columns, values, conflictAction, &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// upsertAlias is the row alias of the inserted values in an upsert.
+const upsertAlias = "new"
+
+// buildUpsertClause builds the ON DUPLICATE KEY UPDATE clause of BulkUpsert,
+// using the row alias syntax of MySQL 8.0.19 and later, which replaces the
+// deprecated VALUES() function.
+func buildUpsertClause(columns, conflictColumns, updateColumns []Column) (string, error) {
+	if len(conflictColumns) == 0 {
+		return "", errors.New("no conflict columns")
+	}
+	for _, c := range conflictColumns {
+		if !slices.Contains(columns, c) {
+			return "", fmt.Errorf("conflict column %q is not inserted", c)
+		}
+	}
+	if len(updateColumns) == 0 {
+		q, err := conflictColumns[0].Quote()
+		if err != nil {
+			return "", err
+		}
+		return doNothingClause(q), nil
+	}
+	sets := make([]string, len(updateColumns))
+	for i, c := range updateColumns {
+		if !slices.Contains(columns, c) {
+			return "", fmt.Errorf("update column %q is not inserted", c)
+		}
+		if slices.Contains(conflictColumns, c) {
+			return "", fmt.Errorf("update column %q is a conflict column", c)
+		}
+		q, err := c.Quote()
+		if err != nil {
+			return "", err
+		}
+		sets[i] = fmt.Sprintf("%s = %s.%s", q, upsertAlias, q)
+	}
+	return fmt.Sprintf("AS %s ON DUPLICATE KEY UPDATE %s", upsertAlias, strings.Join(sets, ", ")), nil
+}
+
+// MaxParameters is the maximum number of parameters of a statement
+// prepared by BulkInsert. Larger inserts are split into several statements.
+const MaxParameters = 1000
+
+// doNothingClause returns an ON DUPLICATE KEY UPDATE clause that leaves
+// conflicting rows unchanged, given a quoted column.
+func doNothingClause(column string) string {
+	// Setting a column to its current value leaves the row unchanged, and
+	// doesn't count it as affected.
+	return fmt.Sprintf("ON DUPLICATE KEY UPDATE %[1]s = %[1]s", column)
+}
+
+// bulkInsert performs batched inserts in a transaction, and returns the
+// number of rows affected. If ids is not nil, the ids of the inserted rows
+// are appended to it.
+func (db *DB) bulkInsert(ctx context.Context, table Table, columns []Column, values []any, conflictAction string, ids *[]int64) (affected int64, err error) {
+	// Names are checked before running any statement.
+	quotedTable, err := table.Quote()
+	if err != nil {
+		return 0, err
+	}
+	quotedColumns, err := quoteColumns(columns)
+	if err != nil {
+		return 0, err
+	}
+
+	if !db.InTransaction() {
+		err = db.Transact(ctx, nil, func(tx *DB) error {
+			affected, err = tx.bulkInsert(ctx, table, columns, values, conflictAction, ids)
+			return err
+		})
+		return affected, err
+	}
+
 	if remainder := len(values) % len(columns); remainder != 0 {
-		return fmt.Errorf("modulus of len(values) and len(columns) must be 0: got %d", remainder)
+		return 0, fmt.Errorf("modulus of len(values) and len(columns) must be 0: got %d", remainder)
 	}
 
-	const maxParameters = 1000
-	stride := (maxParameters / len(columns)) * len(columns)
+	stride := (MaxParameters / len(columns)) * len(columns)
 	if stride == 0 {
-		return fmt.Errorf("too many columns to insert: %d", len(columns))
+		return 0, fmt.Errorf("too many columns to insert: %d", len(columns))
 	}
 
 	prepare := func(n int) (*sql.Stmt, error) {
-		return db.Prepare(ctx, buildInsertQuery(table, columns, returningColumns, n, conflictAction))
+		return db.Prepare(ctx, buildInsertQuery(quotedTable, quotedColumns, n, conflictAction))
 	}
 
 	var stmt *sql.Stmt
@@ -110,30 +431,85 @@ func (db *DB) bulkInsert(ctx context.Context, table string, columns, returningCo
 
 		stmt, err = prepare(rightBound - leftBound)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		defer stmt.Close()
 
+		// Rows inserted by an upsert have larger ids than the rows that
+		// were already stored. LAST_INSERT_ID() may be the id of an
+		// updated row if none was inserted.
+		var maxID int64
+		if ids != nil && conflictAction != "" {
+			query := fmt.Sprintf("SELECT COALESCE(MAX(id), 0) FROM %s", quotedTable)
+			if err := db.QueryRow(ctx, query).Scan(&maxID); err != nil {
+				return 0, err
+			}
+		}
+
 		valueSlice := values[leftBound:rightBound]
-		if returningColumns == nil {
-			_, err = stmt.ExecContext(ctx, valueSlice...)
-		} else {
-			var rows *sql.Rows
-			rows, err = stmt.QueryContext(ctx, valueSlice...)
+		res, err := stmt.ExecContext(ctx, valueSlice...)
+		if err != nil {
+			return 0, fmt.Errorf("running bulk insert query, values[%d:%d]): %w", leftBound, rightBound, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("RowsAffected: %v", err)
+		}
+		affected += n
+
+		if ids != nil {
+			nrows := int64(len(valueSlice) / len(columns))
+			first, err := res.LastInsertId()
 			if err != nil {
-				return err
+				return 0, fmt.Errorf("LastInsertId: %v", err)
+			}
+			if conflictAction != "" {
+				if err := db.appendUpsertedIDs(ctx, quotedTable, first, maxID, nrows, n, ids); err != nil {
+					return 0, err
+				}
+				continue
+			}
+			if n != nrows {
+				return 0, fmt.Errorf("inserted %d rows of %d, cannot tell their ids", n, nrows)
+			}
+			for id := first; id < first+n; id++ {
+				*ids = append(*ids, id)
 			}
-			_, err = processRows(rows, scanFunc)
 		}
+	}
+	return affected, nil
+}
+
+// appendUpsertedIDs appends to ids those of the rows inserted by an upsert
+// of nrows rows, given the LAST_INSERT_ID() of the statement, the largest id
+// before it, and the number of rows it affected.
+func (db *DB) appendUpsertedIDs(ctx context.Context, quotedTable string, first, maxID, nrows, affected int64, ids *[]int64) error {
+	var inserted []int64
+	if from, to := max(first, maxID+1), first+nrows; first > 0 && from < to && affected > 0 {
+		query := fmt.Sprintf("SELECT id FROM %s WHERE id >= ? AND id < ? ORDER BY id", quotedTable)
+		err := db.RunQuery(ctx, query, func(rows *sql.Rows) error {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			inserted = append(inserted, id)
+			return nil
+		}, from, to)
 		if err != nil {
-			return fmt.Errorf("running bulk insert query, values[%d:%d]): %w", leftBound, rightBound, err)
+			return err
 		}
 	}
+	// The other affected rows were updated, and count twice.
+	if k := int64(len(inserted)); k > affected || (affected-k)%2 != 0 || affected-k > 2*(nrows-k) {
+		return fmt.Errorf("found %d inserted rows of %d for %d rows affected, cannot tell their ids", k, nrows, affected)
+	}
+	*ids = append(*ids, inserted...)
 	return nil
 }
 
-// buildInsertQuery builds a multi-value insert query.
-func buildInsertQuery(table string, columns, returningColumns []string, nvalues int, conflictAction string) string {
+// buildInsertQuery builds a multi-value insert query, given the quoted
+// names of the table and columns.
+func buildInsertQuery(table string, columns []string, nvalues int, conflictAction string) string {
 	var b strings.Builder
 	fmt.Fprintf(&b, "INSERT INTO %s (%s) VALUES ", table, strings.Join(columns, ", "))
 
@@ -144,12 +520,12 @@ func buildInsertQuery(table string, columns, returningColumns []string, nvalues
 	}
 	b.WriteString(strings.Join(values, ", "))
 
+	if conflictAction == OnConflictDoNothing {
+		conflictAction = doNothingClause(columns[0])
+	}
 	if conflictAction != "" {
 		b.WriteString(" " + conflictAction)
 	}
-	if len(returningColumns) > 0 {
-		fmt.Fprintf(&b, " RETURNING %s", strings.Join(returningColumns, ", "))
-	}
 	return b.String()
 }
 
@@ -169,6 +545,9 @@ func processRows(rows *sql.Rows, f func(*sql.Rows) error) (int, error) {
 // Prepare prepares a SQL statement for execution.
 func (db *DB) Prepare(ctx context.Context, query string) (*sql.Stmt, error) {
 	defer logQuery(ctx, db.logger, "preparing "+query, nil, db.instanceID)
+	if db.tx != nil {
+		return db.tx.PrepareContext(ctx, query)
+	}
 	return db.db.PrepareContext(ctx, query)
 }
 
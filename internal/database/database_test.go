@@ -2,11 +2,17 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"os"
+	"slices"
 	"testing"
 	"time"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 const testTimeout = 5 * time.Second
@@ -21,7 +27,7 @@ func TestMain(m *testing.M) {
 
 	var err error
 	log.Printf("with driver %q", "mysql")
-	testDB, err = Open("mysql", DBConnURI(testDBName), "test")
+	testDB, err = Open(context.Background(), "mysql", DBConnURI(testDBName), "test")
 	if err != nil {
 		log.Fatalf("Open: %v %[1]T", err)
 	}
@@ -33,11 +39,11 @@ func TestMain(m *testing.M) {
 }
 
 func TestBulkInsert(t *testing.T) {
-	table := "test_bulk_insert"
+	const table = "test_bulk_insert"
 
 	for _, test := range []struct {
 		name           string
-		columns        []string
+		columns        []Column
 		values         []any
 		conflictAction string
 		wantErr        bool
@@ -46,42 +52,48 @@ func TestBulkInsert(t *testing.T) {
 		{
 
 			name:      "test-one-row",
-			columns:   []string{"colA"},
+			columns:   []Column{"colA"},
 			values:    []any{"valueA"},
 			wantCount: 1,
 		},
 		{
 
 			name:      "test-multiple-rows",
-			columns:   []string{"colA"},
+			columns:   []Column{"colA"},
 			values:    []any{"valueA1", "valueA2", "valueA3"},
 			wantCount: 3,
 		},
 		{
 
 			name:    "test-invalid-column-name",
-			columns: []string{"invalid_col"},
+			columns: []Column{"invalid_col"},
+			values:  []any{"valueA"},
+			wantErr: true,
+		},
+		{
+			name:    "test-unquotable-column-name",
+			columns: []Column{"colA) VALUES ('x'); DROP TABLE test_bulk_insert; --"},
 			values:  []any{"valueA"},
 			wantErr: true,
 		},
 		{
 
 			name:    "test-mismatch-num-cols-and-vals",
-			columns: []string{"colA", "colB"},
+			columns: []Column{"colA", "colB"},
 			values:  []any{"valueA1", "valueB1", "valueA2"},
 			wantErr: true,
 		},
 		{
 
 			name:    "test-conflict",
-			columns: []string{"colA"},
+			columns: []Column{"colA"},
 			values:  []any{"valueA", "valueA"},
 			wantErr: true,
 		},
 		{
 
 			name:           "test-conflict-do-nothing",
-			columns:        []string{"colA"},
+			columns:        []Column{"colA"},
 			values:         []any{"valueA", "valueA"},
 			conflictAction: OnConflictDoNothing,
 			wantCount:      1,
@@ -95,7 +107,7 @@ func TestBulkInsert(t *testing.T) {
 			// INSERT INTO series (path) VALUES (''); TRUNCATE series CASCADE;));
 			// which would truncate most tables in the database.
 			name:           "test-sql-injection",
-			columns:        []string{"colA"},
+			columns:        []Column{"colA"},
 			values:         []any{fmt.Sprintf("''); TRUNCATE %s CASCADE;))", table)},
 			conflictAction: OnConflictDoNothing,
 			wantCount:      1,
@@ -145,3 +157,313 @@ func TestBulkInsert(t *testing.T) {
 
 	}
 }
+
+func TestBulkInsertCount(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	const table = "test_bulk_insert_count"
+	if _, err := testDB.Exec(ctx, fmt.Sprintf(`CREATE TABLE %s (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			colA VARCHAR(255) NOT NULL,
+			UNIQUE KEY (colA)
+	);`, table)); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if _, err := testDB.Exec(ctx, "DROP TABLE "+table); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	for _, test := range []struct {
+		values []any
+		want   int64
+	}{
+		{[]any{"a", "b", "c"}, 3},
+		{[]any{"b", "c", "d", "d"}, 1},
+		{[]any{"a"}, 0},
+	} {
+		got, err := testDB.BulkInsertCount(ctx, table, []Column{"colA"}, test.values, OnConflictDoNothing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("BulkInsertCount(%v) = %d, want %d", test.values, got, test.want)
+		}
+	}
+}
+
+func TestBulkUpsert(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	const table = "test_bulk_upsert"
+	if _, err := testDB.Exec(ctx, fmt.Sprintf(`CREATE TABLE %s (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			colA VARCHAR(255) NOT NULL,
+			colB VARCHAR(255),
+			UNIQUE KEY (colA)
+	);`, table)); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if _, err := testDB.Exec(ctx, "DROP TABLE "+table); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	columns := []Column{"colA", "colB"}
+	ids, err := testDB.BulkInsertReturningIDs(ctx, table, columns, []any{"a", "1", "b", "1", "c", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{ids[0], ids[0] + 1, ids[0] + 2}; !slices.Equal(ids, want) || len(ids) != 3 {
+		t.Errorf("BulkInsertReturningIDs: got %v, want 3 consecutive ids", ids)
+	}
+	if _, err := testDB.BulkInsertReturningIDs(ctx, table, columns, []any{"a", "1"}); err == nil {
+		t.Error("BulkInsertReturningIDs: got nil error on conflict, want error")
+	}
+
+	for _, test := range []struct {
+		name          string
+		values        []any
+		updateColumns []Column
+		wantInserted  []string // colA of the rows whose ids are returned
+		want          map[string]string
+	}{
+		{
+			name:         "do nothing",
+			values:       []any{"a", "2", "d", "2"},
+			wantInserted: []string{"d"},
+			want:         map[string]string{"a": "1", "b": "1", "c": "1", "d": "2"},
+		},
+		{
+			name:          "update",
+			values:        []any{"b", "3", "f", "3", "c", "1", "e", "3"},
+			updateColumns: []Column{"colB"},
+			wantInserted:  []string{"f", "e"}, // b updated, c unchanged
+			want:          map[string]string{"a": "1", "b": "3", "c": "1", "d": "2", "e": "3", "f": "3"},
+		},
+		{
+			name:          "conflicts only",
+			values:        []any{"a", "4", "b", "3"},
+			updateColumns: []Column{"colB"},
+			want:          map[string]string{"a": "4", "b": "3", "c": "1", "d": "2", "e": "3", "f": "3"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ids, err := testDB.BulkUpsert(ctx, table, columns, test.values, []Column{"colA"}, test.updateColumns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			byID := map[int64]string{}
+			err = testDB.RunQuery(ctx, "SELECT id, colA, colB FROM "+table, func(rows *sql.Rows) error {
+				var (
+					id   int64
+					a, b string
+				)
+				if err := rows.Scan(&id, &a, &b); err != nil {
+					return err
+				}
+				got[a] = b
+				byID[id] = a
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+			var inserted []string
+			for _, id := range ids {
+				inserted = append(inserted, byID[id])
+			}
+			if !slices.Equal(inserted, test.wantInserted) {
+				t.Errorf("got ids %v of rows %q, want the ids of rows %q", ids, inserted, test.wantInserted)
+			}
+		})
+	}
+}
+
+func TestBuildUpsertClause(t *testing.T) {
+	columns := []Column{"colA", "colB", "colC"}
+	for _, test := range []struct {
+		conflictColumns, updateColumns []Column
+		want                           string
+		wantErr                        bool
+	}{
+		{
+			conflictColumns: []Column{"colB"},
+			want:            "ON DUPLICATE KEY UPDATE `colB` = `colB`",
+		},
+		{
+			conflictColumns: []Column{"colA"},
+			updateColumns:   []Column{"colB", "colC"},
+			want:            "AS new ON DUPLICATE KEY UPDATE `colB` = new.`colB`, `colC` = new.`colC`",
+		},
+		{wantErr: true},
+		{conflictColumns: []Column{"colD"}, wantErr: true},
+		{conflictColumns: []Column{"colA"}, updateColumns: []Column{"colD"}, wantErr: true},
+		{conflictColumns: []Column{"colA"}, updateColumns: []Column{"colA"}, wantErr: true},
+	} {
+		got, err := buildUpsertClause(columns, test.conflictColumns, test.updateColumns)
+		if (err != nil) != test.wantErr {
+			t.Errorf("buildUpsertClause(%v, %v): got error %v, wantErr %t", test.conflictColumns, test.updateColumns, err, test.wantErr)
+		}
+		if got != test.want {
+			t.Errorf("buildUpsertClause(%v, %v) = %q, want %q", test.conflictColumns, test.updateColumns, got, test.want)
+		}
+	}
+}
+
+func TestBuildInsertQuery(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		columns        []string
+		nvalues        int
+		conflictAction string
+		want           string
+	}{
+		{
+			name:    "one row",
+			columns: []string{"`colA`", "`colB`"},
+			nvalues: 2,
+			want:    "INSERT INTO `t` (`colA`, `colB`) VALUES (?,?)",
+		},
+		{
+			name:           "do nothing on conflict",
+			columns:        []string{"`colB`", "`colA`"},
+			nvalues:        4,
+			conflictAction: OnConflictDoNothing,
+			want:           "INSERT INTO `t` (`colB`, `colA`) VALUES (?,?), (?,?) ON DUPLICATE KEY UPDATE `colB` = `colB`",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := buildInsertQuery("`t`", test.columns, test.nvalues, test.conflictAction); got != test.want {
+				t.Errorf("buildInsertQuery() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTransact(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	const table = "test_transact"
+	if _, err := testDB.Exec(ctx, fmt.Sprintf(`CREATE TABLE %s (
+			colA VARCHAR(255) NOT NULL PRIMARY KEY
+	);`, table)); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if _, err := testDB.Exec(ctx, "DROP TABLE "+table); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	count := func() int {
+		t.Helper()
+		var n int
+		if err := testDB.QueryRow(ctx, "SELECT COUNT(*) FROM "+table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	errRollback := errors.New("rollback")
+	err := testDB.Transact(ctx, nil, func(tx *DB) error {
+		if !tx.InTransaction() {
+			t.Error("tx.InTransaction() = false, want true")
+		}
+		if err := tx.BulkInsert(ctx, table, []Column{"colA"}, []any{"a", "b"}, ""); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("Transact: got error %v, want %v", err, errRollback)
+	}
+	if got := count(); got != 0 {
+		t.Errorf("after rollback: got %d rows, want 0", got)
+	}
+
+	err = testDB.Transact(ctx, nil, func(tx *DB) error {
+		if err := testDB.Transact(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(*DB) error { return nil }); err != nil {
+			return err
+		}
+		return tx.BulkInsert(ctx, table, []Column{"colA"}, []any{"a", "b"}, "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := count(); got != 2 {
+		t.Errorf("after commit: got %d rows, want 2", got)
+	}
+
+	if err := testDB.Transact(ctx, nil, func(tx *DB) error {
+		return tx.Transact(ctx, nil, func(*DB) error { return nil })
+	}); err == nil {
+		t.Error("nested Transact: got nil error, want error")
+	}
+
+	// A failing stride rolls back the ones before it.
+	values := make([]any, MaxParameters+1)
+	for i := range values {
+		values[i] = fmt.Sprintf("value%d", i)
+	}
+	values[len(values)-1] = "a"
+	if err := testDB.BulkInsert(ctx, table, []Column{"colA"}, values, ""); err == nil {
+		t.Fatal("BulkInsert: got nil error, want duplicate key error")
+	}
+	if got := count(); got != 2 {
+		t.Errorf("after failed BulkInsert: got %d rows, want 2", got)
+	}
+}
+
+func TestTransactRetry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	deadlock := &mysql.MySQLError{Number: errLockDeadlock, Message: "Deadlock found when trying to get lock"}
+	for _, test := range []struct {
+		name         string
+		err          error
+		wantAttempts int
+	}{
+		{"deadlock", deadlock, maxTransactAttempts},
+		{"lock wait timeout", &mysql.MySQLError{Number: errLockWaitTimeout}, maxTransactAttempts},
+		{"no retry", NoRetry(deadlock), 1},
+		{"duplicate entry", &mysql.MySQLError{Number: 1062}, 1},
+		{"other error", errors.New("bad"), 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			err := testDB.Transact(ctx, nil, func(*DB) error {
+				attempts++
+				return test.err
+			})
+			if !errors.Is(err, test.err) {
+				t.Errorf("got error %v, want %v", err, test.err)
+			}
+			if attempts != test.wantAttempts {
+				t.Errorf("got %d attempts, want %d", attempts, test.wantAttempts)
+			}
+		})
+	}
+
+	// A transaction that succeeds on retry returns no error.
+	attempts := 0
+	err := testDB.Transact(ctx, nil, func(*DB) error {
+		if attempts++; attempts < 3 {
+			return deadlock
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("got error %v after %d attempts, want nil after 3", err, attempts)
+	}
+}
@@ -2,6 +2,8 @@ package database
 
 import (
 	"fmt"
+	"strings"
+	"time"
 )
 
 // DBConfig holds the MySQL database configuration.
@@ -11,6 +13,25 @@ type DBConfig struct {
 	DBUser     string `env:"DATABASE_USER" envDefault:"root" json:"dbUser"`
 	DBPassword string `env:"DATABASE_PASSWORD" envDefault:"example" json:"-"`
 	DBName     string `env:"DATABASE_NAME" envDefault:"fortune_db" json:"dbName"`
+
+	// DBReadHosts are the hosts of the read replicas of the primary, which
+	// must share its port, user, password and database name.
+	DBReadHosts []string `env:"DATABASE_READ_HOSTS" envSeparator:"," json:"dbReadHosts"`
+
+	// Connection pool settings, see the methods of sql.DB of the same names.
+	// Zero means no limit, except for DBMaxIdleConns, for which it means no
+	// idle connections are kept.
+	DBMaxOpenConns    int           `env:"DATABASE_MAX_OPEN_CONNS" envDefault:"20" json:"dbMaxOpenConns"`
+	DBMaxIdleConns    int           `env:"DATABASE_MAX_IDLE_CONNS" envDefault:"10" json:"dbMaxIdleConns"`
+	DBConnMaxLifetime time.Duration `env:"DATABASE_CONN_MAX_LIFETIME" envDefault:"30m" json:"dbConnMaxLifetime"`
+	DBConnMaxIdleTime time.Duration `env:"DATABASE_CONN_MAX_IDLE_TIME" envDefault:"5m" json:"dbConnMaxIdleTime"`
+
+	// Connecting is retried with exponential backoff, waiting from
+	// DBConnectMinBackoff up to DBConnectMaxBackoff between attempts, for
+	// at most DBConnectTimeout in all. Zero means retrying forever.
+	DBConnectTimeout    time.Duration `env:"DATABASE_CONNECT_TIMEOUT" envDefault:"0" json:"dbConnectTimeout"`
+	DBConnectMinBackoff time.Duration `env:"DATABASE_CONNECT_MIN_BACKOFF" envDefault:"1s" json:"dbConnectMinBackoff"`
+	DBConnectMaxBackoff time.Duration `env:"DATABASE_CONNECT_MAX_BACKOFF" envDefault:"30s" json:"dbConnectMaxBackoff"`
 }
 
 // dataSourceName returns a MySQL connection DSN string for the given host.
@@ -25,3 +46,21 @@ func dataSourceName(c DBConfig, host string) string {
 func (c DBConfig) DSN() string {
 	return dataSourceName(c, c.DBHost)
 }
+
+// ServerDSN returns the connection string of the primary database server,
+// without selecting a database, for creating or dropping it.
+func (c DBConfig) ServerDSN() string {
+	c.DBName = ""
+	return c.DSN()
+}
+
+// ReadDSNs returns the connection strings of the read replicas.
+func (c DBConfig) ReadDSNs() []string {
+	var dsns []string
+	for _, host := range c.DBReadHosts {
+		if host = strings.TrimSpace(host); host != "" {
+			dsns = append(dsns, dataSourceName(c, host))
+		}
+	}
+	return dsns
+}
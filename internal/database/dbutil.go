@@ -156,6 +156,9 @@ func ResetDB(ctx context.Context, db *DB) error {
 	if _, err := db.Exec(ctx, `TRUNCATE TABLE fortune_cookies;`); err != nil {
 		return fmt.Errorf("error resetting test DB: %v", err)
 	}
+	if _, err := db.Exec(ctx, `TRUNCATE TABLE collections;`); err != nil {
+		return fmt.Errorf("error resetting test DB: %v", err)
+	}
 	if _, err := db.Exec(ctx, `SET FOREIGN_KEY_CHECKS = 1;`); err != nil {
 		return fmt.Errorf("error resetting test DB: %v", err)
 	}
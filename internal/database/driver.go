@@ -8,7 +8,6 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
-	"errors"
 
 	"contrib.go.opencensus.io/integrations/ocsql"
 )
@@ -68,7 +67,9 @@ func (c *wrapConn) Ping(ctx context.Context) error { return c.oc.Ping(ctx) }
 
 func (c *wrapConn) Prepare(query string) (driver.Stmt, error) { return c.oc.Prepare(query) }
 func (c *wrapConn) Close() error                              { return c.oc.Close() }
-func (c *wrapConn) Begin() (driver.Tx, error)                 { return nil, errors.New("unimplmented") }
+func (c *wrapConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
 
 func (c *wrapConn) ExecContext(ctx context.Context, q string, args []driver.NamedValue) (driver.Result, error) {
 	return c.oc.ExecContext(ctx, q, args)
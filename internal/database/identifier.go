@@ -0,0 +1,59 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Table is the name of a table, as accepted by BulkInsert and other query
+// builders. It is checked and quoted before being spliced into SQL.
+type Table string
+
+// Column is the name of a column, as accepted by BulkInsert and other query
+// builders. It is checked and quoted before being spliced into SQL.
+type Column string
+
+// identifierRegexp matches the identifiers accepted by the query builders:
+// a letter or underscore followed by letters, digits or underscores, up to
+// the 64 characters MySQL allows. This is narrower than what MySQL accepts
+// in quoted identifiers, on purpose.
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// Quote returns t quoted with backticks, or an error if it is not a valid
+// identifier.
+func (t Table) Quote() (string, error) {
+	return quoteIdentifier("table", string(t))
+}
+
+// Quote returns c quoted with backticks, or an error if it is not a valid
+// identifier.
+func (c Column) Quote() (string, error) {
+	return quoteIdentifier("column", string(c))
+}
+
+// QuoteDatabase returns the database name quoted with backticks, or an error
+// if it is not a valid identifier.
+func QuoteDatabase(name string) (string, error) {
+	return quoteIdentifier("database", name)
+}
+
+func quoteIdentifier(kind, name string) (string, error) {
+	if !identifierRegexp.MatchString(name) {
+		return "", fmt.Errorf("invalid %s name %q", kind, name)
+	}
+	// Valid identifiers contain no backticks, so none need to be escaped.
+	return "`" + name + "`", nil
+}
+
+// quoteColumns quotes each of columns, failing on the first invalid one.
+func quoteColumns(columns []Column) ([]string, error) {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		q, err := c.Quote()
+		if err != nil {
+			return nil, err
+		}
+		quoted[i] = q
+	}
+	return quoted, nil
+}
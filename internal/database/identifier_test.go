@@ -0,0 +1,68 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "fortune_cookies", want: "`fortune_cookies`"},
+		{name: "_x1", want: "`_x1`"},
+		{name: "order", want: "`order`"},
+		{name: strings.Repeat("a", 64), want: "`" + strings.Repeat("a", 64) + "`"},
+		{name: "", wantErr: true},
+		{name: "1st", wantErr: true},
+		{name: "a-b", wantErr: true},
+		{name: "a.b", wantErr: true},
+		{name: "a`b", wantErr: true},
+		{name: "a b", wantErr: true},
+		{name: "ä", wantErr: true},
+		{name: strings.Repeat("a", 65), wantErr: true},
+	} {
+		got, err := Table(test.name).Quote()
+		if (err != nil) != test.wantErr {
+			t.Errorf("Table(%q).Quote(): got error %v, wantErr %t", test.name, err, test.wantErr)
+		}
+		if got != test.want {
+			t.Errorf("Table(%q).Quote() = %q, want %q", test.name, got, test.want)
+		}
+		if got, _ := Column(test.name).Quote(); got != test.want {
+			t.Errorf("Column(%q).Quote() = %q, want %q", test.name, got, test.want)
+		}
+		if got, _ := QuoteDatabase(test.name); got != test.want {
+			t.Errorf("QuoteDatabase(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBulkInsertInvalidNames(t *testing.T) {
+	// Names are checked before using the connection, so none is needed.
+	db := New(nil, "test")
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		table   Table
+		columns []Column
+		wantErr string
+	}{
+		{"t; DROP TABLE t", []Column{"a"}, `invalid table name "t; DROP TABLE t"`},
+		{"t", []Column{"a", "b c"}, `invalid column name "b c"`},
+	} {
+		err := db.BulkInsert(ctx, test.table, test.columns, []any{1, 2}, "")
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("BulkInsert(%q, %q): got error %v, want %q", test.table, test.columns, err, test.wantErr)
+		}
+	}
+	// The update column is inserted too, so that it is only rejected for
+	// its name.
+	const wantErr = "invalid column name \"b`\""
+	if _, err := db.BulkUpsert(ctx, "t", []Column{"a", "b`"}, []any{1, 2}, []Column{"a"}, []Column{"b`"}); err == nil || !strings.Contains(err.Error(), wantErr) {
+		t.Errorf("BulkUpsert with an invalid update column: got error %v, want %q", err, wantErr)
+	}
+}
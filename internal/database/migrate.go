@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/mysql"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/tetsuo/fortune/internal/wraperr"
+)
+
+// migrateLockName is the name of the advisory lock held while migrating.
+const migrateLockName = "fortune.migrate"
+
+// Migrate applies the migrations in fsys that weren't applied to the primary
+// database yet, and returns the resulting schema version.
+//
+// It holds a MySQL advisory lock while migrating, so that concurrent calls,
+// such as those of the replicas of a deployment during a rollout, run one
+// after the other, and all but the first find nothing to do. It waits at
+// most lockTimeout for the lock; a negative lockTimeout means forever.
+func (db *DB) Migrate(ctx context.Context, fsys fs.FS, lockTimeout time.Duration) (version uint, err error) {
+	defer wraperr.Wrap(&err, "DB.Migrate(ctx, fsys, %s)", lockTimeout)
+
+	err = db.runMigrate(ctx, fsys, lockTimeout, func(m *migrate.Migrate) error {
+		if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return err
+		}
+		var dirty bool
+		if version, dirty, err = m.Version(); err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("migration %d is dirty", version)
+		}
+		return nil
+	})
+	return version, err
+}
+
+// RunMigrate calls f with a golang-migrate instance for the migrations in
+// fsys and the primary database, holding the advisory lock of Migrate.
+func (db *DB) RunMigrate(ctx context.Context, fsys fs.FS, lockTimeout time.Duration, f func(*migrate.Migrate) error) (err error) {
+	defer wraperr.Wrap(&err, "DB.RunMigrate(ctx, fsys, %s, f)", lockTimeout)
+	return db.runMigrate(ctx, fsys, lockTimeout, f)
+}
+
+func (db *DB) runMigrate(ctx context.Context, fsys fs.FS, lockTimeout time.Duration, f func(*migrate.Migrate) error) (err error) {
+	if db.tx != nil {
+		return errors.New("cannot migrate in a transaction")
+	}
+
+	// Advisory locks belong to the session, so the lock must be taken,
+	// and released, on the connection that migrates.
+	conn, err := db.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := getLock(ctx, conn, migrateLockName, lockTimeout); err != nil {
+		return err
+	}
+	defer func() {
+		// The connection goes back to the pool when closed, so the lock
+		// must be released explicitly.
+		if _, rerr := conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?)`, migrateLockName); rerr != nil && err == nil {
+			err = rerr
+		}
+	}()
+
+	// The golang-migrate instance isn't closed, since that would close conn
+	// before the lock is released, so its source is closed here.
+	src, err := iofs.New(fsys, ".")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	driver, err := mysql.WithConnection(ctx, conn, &mysql.Config{})
+	if err != nil {
+		return err
+	}
+	m, err := migrate.NewWithInstance("iofs", src, "mysql", driver)
+	if err != nil {
+		return err
+	}
+	return f(m)
+}
+
+// getLock acquires the advisory lock named name on conn, waiting at most
+// timeout for it, or forever if timeout is negative.
+func getLock(ctx context.Context, conn *sql.Conn, name string, timeout time.Duration) error {
+	seconds := -1
+	if timeout >= 0 {
+		seconds = int(math.Ceil(timeout.Seconds()))
+	}
+	var ok sql.NullBool
+	if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, name, seconds).Scan(&ok); err != nil {
+		return err
+	}
+	if !ok.Valid || !ok.Bool {
+		return fmt.Errorf("timed out after %s waiting for lock %q", timeout, name)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"io/fs"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tetsuo/fortune/etc/migrations"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// Migrations run on a database of their own, since testDB has tables
+	// of other tests.
+	const dbName = "fortune_mysql_migrate_test"
+	if err := recreateDB(dbName); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := DropDB(dbName); err != nil {
+			t.Error(err)
+		}
+	}()
+	db, err := Open(ctx, "mysql", DBConnURI(dbName), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ups, err := fs.Glob(migrations.FS, "*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := uint(len(ups))
+
+	// Concurrent calls take turns.
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := db.Migrate(ctx, migrations.FS, time.Minute)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got != want {
+				t.Errorf("Migrate: got version %d, want %d", got, want)
+			}
+		}()
+	}
+	wg.Wait()
+
+	version, dirty, err := db.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != want || dirty {
+		t.Errorf("SchemaVersion() = %d, %t, want %d, false", version, dirty, want)
+	}
+
+	// Migrate gives up if another process holds the lock for too long.
+	conn, err := db.db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := getLock(ctx, conn, migrateLockName, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Migrate(ctx, migrations.FS, time.Second); err == nil {
+		t.Error("Migrate with lock held: got nil error, want timeout")
+	}
+	if _, err := conn.ExecContext(ctx, `SELECT RELEASE_LOCK(?)`, migrateLockName); err != nil {
+		t.Fatal(err)
+	}
+}
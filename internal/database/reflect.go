@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// CollectStructs runs the query and scans each of its rows into a struct of
+// type T. Each column is stored in the exported field of T whose db tag, or
+// else name, matches the column name, ignoring case. Fields that match no
+// column are left zero, but a column that matches no field is an error.
+//
+// Example:
+//
+//	type Player struct {
+//		Name   string
+//		Score  int
+//		TeamID int64 `db:"team_id"`
+//	}
+//	players, err := database.CollectStructs[Player](ctx, db, "SELECT name, score, team_id FROM players")
+func CollectStructs[T any](ctx context.Context, db *DB, query string, args ...any) ([]T, error) {
+	var (
+		ts     []T
+		fields func(*T) []any
+	)
+	err := db.RunQuery(ctx, query, func(rows *sql.Rows) error {
+		if fields == nil {
+			columns, err := rows.Columns()
+			if err != nil {
+				return err
+			}
+			if fields, err = structFields[T](columns); err != nil {
+				return err
+			}
+		}
+		var t T
+		if err := rows.Scan(fields(&t)...); err != nil {
+			return err
+		}
+		ts = append(ts, t)
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// structFields returns a function that, given a pointer to a struct of type
+// T, returns pointers to its fields matching the given columns, in the same
+// order, for use with sql.Rows.Scan.
+func structFields[T any](columns []string) (func(*T) []any, error) {
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s is not a struct type", t)
+	}
+
+	byName := map[string]int{}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Tag.Get("db")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		byName[strings.ToLower(name)] = i
+	}
+
+	indexes := make([]int, len(columns))
+	for i, c := range columns {
+		j, ok := byName[strings.ToLower(c)]
+		if !ok {
+			return nil, fmt.Errorf("no field of %s for column %q", t, c)
+		}
+		indexes[i] = j
+	}
+
+	return func(p *T) []any {
+		v := reflect.ValueOf(p).Elem()
+		ptrs := make([]any, len(indexes))
+		for i, j := range indexes {
+			ptrs[i] = v.Field(j).Addr().Interface()
+		}
+		return ptrs
+	}, nil
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+type player struct {
+	Name   string
+	Score  int
+	TeamID *int64 `db:"team_id"`
+	Hidden string `db:"-"`
+	secret string
+}
+
+func TestStructFields(t *testing.T) {
+	fields, err := structFields[player]([]string{"SCORE", "team_id", "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p player
+	got := fields(&p)
+	want := []any{&p.Score, &p.TeamID, &p.Name}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, columns := range [][]string{
+		{"name", "teamid"},
+		{"hidden"},
+		{"secret"},
+	} {
+		if _, err := structFields[player](columns); err == nil {
+			t.Errorf("structFields(%q): got nil error, want error", columns)
+		}
+	}
+	if _, err := structFields[int]([]string{"name"}); err == nil {
+		t.Error("structFields[int]: got nil error, want error")
+	}
+}
+
+func TestCollectStructs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	const table = "test_collect_structs"
+	if _, err := testDB.Exec(ctx, `CREATE TABLE `+table+` (
+			name VARCHAR(255) NOT NULL PRIMARY KEY,
+			score INT NOT NULL,
+			team_id BIGINT
+	);`); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if _, err := testDB.Exec(ctx, "DROP TABLE "+table); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if err := testDB.BulkInsert(ctx, table, []Column{"name", "score", "team_id"},
+		[]any{"ann", 3, 7, "bob", 5, nil}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := CollectStructs[player](ctx, testDB, `SELECT name, score, team_id FROM `+table+` ORDER BY name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := int64(7)
+	want := []player{{Name: "ann", Score: 3, TeamID: &team}, {Name: "bob", Score: 5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	var names []string
+	err = testDB.RunQuery(ctx, `SELECT name FROM `+table+` WHERE score > ?`, func(rows *sql.Rows) error {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		names = append(names, name)
+		return nil
+	}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"bob"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("RunQuery: got %q, want %q", names, want)
+	}
+
+	if _, err := CollectStructs[player](ctx, testDB, `SELECT name, score AS points FROM `+table); err == nil {
+		t.Error("got nil error for a column without a field, want error")
+	}
+}
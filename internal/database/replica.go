@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/tetsuo/fortune/internal/wraperr"
+)
+
+// Health checks of read replicas.
+const (
+	replicaCheckInterval = 5 * time.Second
+	replicaCheckTimeout  = 2 * time.Second
+)
+
+// replica is a read replica of the primary database.
+type replica struct {
+	db      *sql.DB
+	host    string // address of the server, for logging
+	healthy atomic.Bool
+}
+
+// replicaPool spreads reads over the healthy replicas, in turn.
+type replicaPool struct {
+	replicas []*replica
+	next     atomic.Uint64
+	stop     chan struct{}
+	done     sync.WaitGroup
+}
+
+// pick returns the next healthy replica, or nil if there is none.
+func (p *replicaPool) pick() *replica {
+	n := uint64(len(p.replicas))
+	for range n {
+		// Unhealthy replicas take their turn too, so that the next healthy
+		// one isn't picked twice as often.
+		if r := p.replicas[p.next.Add(1)%n]; r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// check pings every replica, and updates whether it is healthy.
+func (p *replicaPool) check(db *DB) {
+	for _, r := range p.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), replicaCheckTimeout)
+		err := r.db.PingContext(ctx)
+		cancel()
+		if healthy := err == nil; r.healthy.Swap(healthy) != healthy {
+			if healthy {
+				db.logger.Infof("read replica %s is healthy", r.host)
+			} else {
+				db.logger.Warnf("read replica %s is unhealthy: %v", r.host, err)
+			}
+		}
+	}
+}
+
+// run checks the replicas periodically, until the pool is closed.
+func (p *replicaPool) run(db *DB) {
+	defer p.done.Done()
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.check(db)
+		}
+	}
+}
+
+// close stops the health checks and closes the replicas.
+func (p *replicaPool) close() error {
+	close(p.stop)
+	p.done.Wait()
+	var firstErr error
+	for _, r := range p.replicas {
+		if err := r.db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// OpenReplicas opens a connection pool to each of the read replicas at the
+// given data sources, and routes the reads of db to them, in turn. Replicas
+// are checked periodically, and only the healthy ones are read from; reads
+// go to the primary when none is. It must be called before db is used
+// concurrently.
+func (db *DB) OpenReplicas(driverName string, dsns []string) (err error) {
+	defer wraperr.Wrap(&err, "DB.OpenReplicas(%q, [%d data sources])", driverName, len(dsns))
+
+	if len(dsns) == 0 {
+		return nil
+	}
+
+	p := &replicaPool{stop: make(chan struct{})}
+	for _, dsn := range dsns {
+		sdb, err := sql.Open(driverName, dsn)
+		if err != nil {
+			for _, r := range p.replicas {
+				r.db.Close()
+			}
+			return err
+		}
+		p.replicas = append(p.replicas, &replica{db: sdb, host: dsnHost(dsn)})
+	}
+	// A replica that cannot be reached yet is only read from once it can.
+	p.check(db)
+
+	p.done.Add(1)
+	go p.run(db)
+
+	db.replicas = p
+	return nil
+}
+
+// dsnHost returns the address of the server of a MySQL data source, so that
+// a replica is logged without its credentials or parameters.
+func dsnHost(dsn string) string {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "(invalid data source)"
+	}
+	return cfg.Addr
+}
+
+type primaryKey struct{}
+
+// WithPrimary returns a context with which the reads of a DB go to the
+// primary rather than to a replica, so that they see the writes that were
+// just made, which replicas may not have applied yet.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// reader returns the pool to read from: a healthy replica, unless ctx
+// requires the primary or there are none.
+func (db *DB) reader(ctx context.Context) *sql.DB {
+	if db.replicas == nil || ctx.Value(primaryKey{}) != nil {
+		return db.db
+	}
+	if r := db.replicas.pick(); r != nil {
+		return r.db
+	}
+	return db.db
+}
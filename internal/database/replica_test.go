@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestReplicaPoolPick(t *testing.T) {
+	a, b, c := &replica{host: "a"}, &replica{host: "b"}, &replica{host: "c"}
+	a.healthy.Store(true)
+	c.healthy.Store(true)
+	p := &replicaPool{replicas: []*replica{a, b, c}}
+
+	counts := map[string]int{}
+	for range 10 {
+		counts[p.pick().host]++
+	}
+	if counts["a"] != 5 || counts["c"] != 5 {
+		t.Errorf("got picks %v, want 5 each of a and c", counts)
+	}
+
+	a.healthy.Store(false)
+	c.healthy.Store(false)
+	if r := p.pick(); r != nil {
+		t.Errorf("pick() = %s, want nil with no healthy replica", r.host)
+	}
+}
+
+func TestReader(t *testing.T) {
+	ctx := context.Background()
+	primary, replicaDB := &sql.DB{}, &sql.DB{}
+	db := New(primary, "test")
+	if got := db.reader(ctx); got != primary {
+		t.Error("without replicas: reader is not the primary")
+	}
+
+	r := &replica{db: replicaDB, host: "r"}
+	r.healthy.Store(true)
+	db.replicas = &replicaPool{replicas: []*replica{r}}
+	if got := db.reader(ctx); got != replicaDB {
+		t.Error("reader is not the replica")
+	}
+	if got := db.reader(WithPrimary(ctx)); got != primary {
+		t.Error("WithPrimary: reader is not the primary")
+	}
+	r.healthy.Store(false)
+	if got := db.reader(ctx); got != primary {
+		t.Error("with an unhealthy replica: reader is not the primary")
+	}
+}
+
+func TestOpenReplicas(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	db := New(testDB.db, "test")
+	unreachable := "root:example@tcp(127.0.0.1:1)/" + testDBName
+	if err := db.OpenReplicas("mysql", []string{DBConnURI(testDBName), unreachable}); err != nil {
+		t.Fatal(err)
+	}
+	// Closing db would close the primary, which is shared with testDB.
+	defer db.replicas.close()
+
+	if healthy := db.replicas.replicas[0].healthy.Load(); !healthy {
+		t.Error("reachable replica is not healthy")
+	}
+	if healthy := db.replicas.replicas[1].healthy.Load(); healthy {
+		t.Error("unreachable replica is healthy")
+	}
+	if host := db.replicas.replicas[1].host; host != "127.0.0.1:1" {
+		t.Errorf("got host %q, want 127.0.0.1:1", host)
+	}
+	for range 3 {
+		var n int
+		if err := db.QueryRow(ctx, "SELECT 1").Scan(&n); err != nil || n != 1 {
+			t.Fatalf("QueryRow: got %d, %v, want 1", n, err)
+		}
+	}
+}
+
+func TestDSNHost(t *testing.T) {
+	for _, test := range []struct{ dsn, want string }{
+		{"root:secret@tcp(db.example.com:3306)/fortune?parseTime=true", "db.example.com:3306"},
+		{"root@tcp(10.0.0.2)/fortune", "10.0.0.2:3306"},
+		{"root:secret@/fortune", "127.0.0.1:3306"},
+		{"root:secret@tcp(db:3306)", "(invalid data source)"},
+	} {
+		if got := dsnHost(test.dsn); got != test.want {
+			t.Errorf("dsnHost(%q) = %q, want %q", test.dsn, got, test.want)
+		}
+	}
+}
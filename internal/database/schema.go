@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/tetsuo/fortune/internal/wraperr"
+)
+
+// Ping verifies that the primary database is reachable.
+func (db *DB) Ping(ctx context.Context) (err error) {
+	defer wraperr.Wrap(&err, "DB.Ping")
+	return db.db.PingContext(ctx)
+}
+
+// errNoSuchTable is the MySQL error number of queries on a table that
+// doesn't exist.
+const errNoSuchTable = 1146 // ER_NO_SUCH_TABLE
+
+// SchemaVersion returns the version of the last migration applied to the
+// primary database, and whether it failed part way, as recorded by
+// golang-migrate in the schema_migrations table. The version is 0 if no
+// migration was applied, or the table doesn't exist.
+func (db *DB) SchemaVersion(ctx context.Context) (version uint, dirty bool, err error) {
+	defer wraperr.Wrap(&err, "DB.SchemaVersion")
+
+	err = db.QueryRow(WithPrimary(ctx), `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	var merr *mysql.MySQLError
+	if errors.Is(err, sql.ErrNoRows) || errors.As(err, &merr) && merr.Number == errNoSuchTable {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// LatestVersion returns the version of the last migration in fsys, in the
+// format of golang-migrate, or 0 if there is none.
+func LatestVersion(fsys fs.FS) (_ uint, err error) {
+	defer wraperr.Wrap(&err, "LatestVersion")
+
+	src, err := iofs.New(fsys, ".")
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	version, err := src.First()
+	for err == nil {
+		var next uint
+		if next, err = src.Next(version); err == nil {
+			version = next
+		}
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	return version, nil
+}
+
+// SchemaState compares the schema version of a database with the migrations
+// known to the binary.
+type SchemaState struct {
+	Version uint // last migration applied, 0 if none
+	Dirty   bool // whether the last migration failed part way
+	Latest  uint // last migration known to the binary
+}
+
+// Current reports whether the last migration known to the binary was
+// applied successfully, and no later one.
+func (s SchemaState) Current() bool {
+	return s.Version == s.Latest && !s.Dirty
+}
+
+func (s SchemaState) String() string {
+	var state string
+	switch {
+	case s.Dirty:
+		state = "dirty"
+	case s.Version < s.Latest:
+		state = "behind"
+	case s.Version > s.Latest:
+		state = "ahead"
+	default:
+		state = "current"
+	}
+	return fmt.Sprintf("version %d, %s (latest migration %d)", s.Version, state, s.Latest)
+}
+
+// CheckSchema compares the schema version of the primary database with the
+// last migration in fsys.
+func (db *DB) CheckSchema(ctx context.Context, fsys fs.FS) (SchemaState, error) {
+	var (
+		s   SchemaState
+		err error
+	)
+	if s.Latest, err = LatestVersion(fsys); err != nil {
+		return s, err
+	}
+	s.Version, s.Dirty, err = db.SchemaVersion(ctx)
+	return s, err
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/tetsuo/fortune/etc/migrations"
+)
+
+func TestLatestVersion(t *testing.T) {
+	ups, err := fs.Glob(migrations.FS, "*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name string
+		fsys fs.FS
+		want uint
+	}{
+		{"embedded", migrations.FS, uint(len(ups))},
+		{"gaps", fstest.MapFS{
+			"1_a.up.sql":   {},
+			"1_a.down.sql": {},
+			"3_b.up.sql":   {},
+			"10_c.up.sql":  {},
+			"README.md":    {},
+		}, 10},
+		{"empty", fstest.MapFS{}, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := LatestVersion(test.fsys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSchemaState(t *testing.T) {
+	for _, test := range []struct {
+		state       SchemaState
+		wantCurrent bool
+		wantString  string
+	}{
+		{SchemaState{Version: 7, Latest: 7}, true, "version 7, current (latest migration 7)"},
+		{SchemaState{Version: 6, Latest: 7}, false, "version 6, behind (latest migration 7)"},
+		{SchemaState{Version: 0, Latest: 7}, false, "version 0, behind (latest migration 7)"},
+		{SchemaState{Version: 8, Latest: 7}, false, "version 8, ahead (latest migration 7)"},
+		{SchemaState{Version: 7, Dirty: true, Latest: 7}, false, "version 7, dirty (latest migration 7)"},
+	} {
+		if got := test.state.Current(); got != test.wantCurrent {
+			t.Errorf("%+v.Current() = %t, want %t", test.state, got, test.wantCurrent)
+		}
+		if got := test.state.String(); got != test.wantString {
+			t.Errorf("%+v.String() = %q, want %q", test.state, got, test.wantString)
+		}
+	}
+}
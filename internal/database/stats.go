@@ -0,0 +1,93 @@
+package database
+
+import (
+	"database/sql"
+
+	"go.opencensus.io/metric"
+	"go.opencensus.io/metric/metricdata"
+	"go.opencensus.io/metric/metricproducer"
+)
+
+// ConfigurePool applies the connection pool settings of cfg to db, and to
+// each of its read replicas.
+func (db *DB) ConfigurePool(cfg DBConfig) {
+	pools := []*sql.DB{db.db}
+	if db.replicas != nil {
+		for _, r := range db.replicas.replicas {
+			pools = append(pools, r.db)
+		}
+	}
+	for _, p := range pools {
+		p.SetMaxOpenConns(cfg.DBMaxOpenConns)
+		p.SetMaxIdleConns(cfg.DBMaxIdleConns)
+		p.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
+		p.SetConnMaxIdleTime(cfg.DBConnMaxIdleTime)
+	}
+}
+
+// Stats returns the statistics of the connection pool of the primary.
+func (db *DB) Stats() sql.DBStats {
+	return db.db.Stats()
+}
+
+// poolMetric is an OpenCensus metric derived from sql.DBStats.
+type poolMetric struct {
+	name        string
+	description string
+	unit        metricdata.Unit
+	cumulative  bool // whether the value only grows, as opposed to a gauge
+	value       func(sql.DBStats) float64
+}
+
+var poolMetrics = []poolMetric{
+	{"max_open", "Maximum number of open connections to the database", metricdata.UnitDimensionless, false,
+		func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+	{"open", "Number of established connections, in use or idle", metricdata.UnitDimensionless, false,
+		func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+	{"in_use", "Number of connections in use", metricdata.UnitDimensionless, false,
+		func(s sql.DBStats) float64 { return float64(s.InUse) }},
+	{"idle", "Number of idle connections", metricdata.UnitDimensionless, false,
+		func(s sql.DBStats) float64 { return float64(s.Idle) }},
+	{"wait_count", "Total number of connections waited for", metricdata.UnitDimensionless, true,
+		func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+	{"wait_duration", "Total time blocked waiting for a new connection, in seconds", metricdata.UnitDimensionless, true,
+		func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+	{"max_idle_closed", "Total number of connections closed due to DATABASE_MAX_IDLE_CONNS", metricdata.UnitDimensionless, true,
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }},
+	{"max_idle_time_closed", "Total number of connections closed due to DATABASE_CONN_MAX_IDLE_TIME", metricdata.UnitDimensionless, true,
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }},
+	{"max_lifetime_closed", "Total number of connections closed due to DATABASE_CONN_MAX_LIFETIME", metricdata.UnitDimensionless, true,
+		func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }},
+}
+
+// RegisterPoolMetrics exports the statistics of the connection pool of the
+// primary as OpenCensus metrics named fortune/db/connections/*, which are read
+// whenever metrics are exported, as by the Prometheus exporter. It should
+// be called once per DB. The returned function unregisters them.
+func (db *DB) RegisterPoolMetrics() (unregister func(), err error) {
+	r := metric.NewRegistry()
+	for _, m := range poolMetrics {
+		opts := []metric.Options{metric.WithDescription(m.description), metric.WithUnit(m.unit)}
+		name := "fortune/db/connections/" + m.name
+		value := func() float64 { return m.value(db.db.Stats()) }
+		if m.cumulative {
+			c, err := r.AddFloat64DerivedCumulative(name, opts...)
+			if err != nil {
+				return nil, err
+			}
+			if err := c.UpsertEntry(value); err != nil {
+				return nil, err
+			}
+		} else {
+			g, err := r.AddFloat64DerivedGauge(name, opts...)
+			if err != nil {
+				return nil, err
+			}
+			if err := g.UpsertEntry(value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	metricproducer.GlobalManager().AddProducer(r)
+	return func() { metricproducer.GlobalManager().DeleteProducer(r) }, nil
+}
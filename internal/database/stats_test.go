@@ -0,0 +1,52 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"go.opencensus.io/metric/metricdata"
+	"go.opencensus.io/metric/metricproducer"
+)
+
+func TestRegisterPoolMetrics(t *testing.T) {
+	db := New(testDB.db, "test")
+	db.ConfigurePool(DBConfig{DBMaxOpenConns: 7, DBMaxIdleConns: 2, DBConnMaxLifetime: time.Hour})
+	// Restore the defaults of sql.DB.
+	defer testDB.ConfigurePool(DBConfig{DBMaxIdleConns: 2})
+
+	unregister, err := db.RegisterPoolMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]float64{}
+	types := map[string]metricdata.Type{}
+	for _, p := range metricproducer.GlobalManager().GetAll() {
+		for _, m := range p.Read() {
+			if len(m.TimeSeries) == 1 && len(m.TimeSeries[0].Points) == 1 {
+				values[m.Descriptor.Name] = m.TimeSeries[0].Points[0].Value.(float64)
+				types[m.Descriptor.Name] = m.Descriptor.Type
+			}
+		}
+	}
+	for _, m := range poolMetrics {
+		if _, ok := values["fortune/db/connections/"+m.name]; !ok {
+			t.Errorf("metric %q not exported", m.name)
+		}
+	}
+	if got := values["fortune/db/connections/max_open"]; got != 7 {
+		t.Errorf("max_open = %v, want 7", got)
+	}
+	if got, want := types["fortune/db/connections/wait_count"], metricdata.TypeCumulativeFloat64; got != want {
+		t.Errorf("wait_count has type %v, want %v", got, want)
+	}
+
+	unregister()
+	for _, p := range metricproducer.GlobalManager().GetAll() {
+		for _, m := range p.Read() {
+			if m.Descriptor.Name == "fortune/db/connections/max_open" {
+				t.Error("metrics still exported after unregister")
+			}
+		}
+	}
+}
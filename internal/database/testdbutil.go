@@ -43,7 +43,7 @@ func SetupTestDB(dbName string) (_ *DB, err error) {
 		}
 	}
 
-	db, err := Open("mysql", DBConnURI(dbName), "test")
+	db, err := Open(context.Background(), "mysql", DBConnURI(dbName), "test")
 	if err != nil {
 		return nil, err
 	}
@@ -4,5 +4,5 @@
 
 source scripts/lib.sh || { echo "Are you at repo root?"; exit 1; }
 
-go run devtools/cmd/db/main.go create
+go run ./devtools/cmd/db create
 ./scripts/migrate_db.sh up
@@ -8,5 +8,5 @@ for dbname in \
     "fortune_mysql_test_1" \
     "fortune_mysql_test_2" \
     "fortune_mysql_test_3"; do
-    DATABASE_NAME=$dbname LOG_LEVEL=info go run devtools/cmd/db/main.go drop
+    DATABASE_NAME=$dbname LOG_LEVEL=info go run ./devtools/cmd/db drop
 done